@@ -5,6 +5,7 @@ go 1.24
 toolchain go1.25.9
 
 require (
+	github.com/google/gopacket v1.1.19
 	github.com/spf13/cobra v1.8.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.15.0
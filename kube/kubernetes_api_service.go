@@ -3,10 +3,13 @@ package kube
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -16,13 +19,92 @@ import (
 	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/transport/spdy"
 	"log"
+	"net"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// NetshootImage is the default image of the ephemeral containers and debug
+// pods; WithImage replaces it, e.g. with a mirror in a private registry.
 const NetshootImage = "campvin/netshoot-docker:latest"
 
+// Deployment revision selectors accepted by ListDeploymentPods.
+const (
+	RevisionNew = "new"
+	RevisionOld = "old"
+	RevisionAll = "all"
+)
+
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Container roles reported by DetectContainerRole.
+const (
+	RoleApp     = "app"
+	RoleSidecar = "sidecar"
+	RoleGateway = "gateway"
+	RoleInit    = "init"
+	// RoleEphemeral marks debug containers such as the ones xDSnap injects.
+	RoleEphemeral = "ephemeral"
+)
+
+// ContainerInfo describes one container of a pod and its detected role.
+type ContainerInfo struct {
+	Name  string
+	Image string
+	Role  string
+}
+
+// gatewayContainerPrefixes match Consul gateway containers (supports *-tls, *-dc1, etc.).
+var gatewayContainerPrefixes = []string{"api-gateway", "mesh-gateway", "ingress-gateway", "terminating-gateway"}
+
+// sidecarContainerNames are the workload dataplane containers, in preference order.
+var sidecarContainerNames = []string{"consul-dataplane", "envoy-sidecar"}
+
+// DetectContainerRole classifies a container by name as a gateway, a dataplane
+// sidecar, or an application container.
+func DetectContainerRole(name string) string {
+	for _, prefix := range gatewayContainerPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return RoleGateway
+		}
+	}
+	for _, sidecar := range sidecarContainerNames {
+		if name == sidecar {
+			return RoleSidecar
+		}
+	}
+	return RoleApp
+}
+
+// FindContainerByRole returns the first container whose detected role matches.
+func FindContainerByRole(containers []string, role string) (string, bool) {
+	for _, name := range containers {
+		if DetectContainerRole(name) == role {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// DefaultMetricsPort is consul-dataplane's merged Prometheus metrics port.
+const DefaultMetricsPort = 20200
+
+// EphemeralCaptureDir is where file-based tcpdump captures are written inside
+// the ephemeral container. The container stays alive until the files have been
+// collected (see ReleaseEphemeralCapture) or a safety timeout elapses.
+const EphemeralCaptureDir = "/tmp/xdsnap"
+
+const (
+	ephemeralCaptureDoneMarker      = EphemeralCaptureDir + "/.done"
+	ephemeralCaptureCollectedMarker = EphemeralCaptureDir + "/.collected"
+	ephemeralCaptureLingerSeconds   = 600
+)
+
 type KubernetesApiService interface {
 	ExecuteCommand(pod string, container string, command []string, output io.Writer) (int, error)
 	ExecuteCommandWithStderr(pod string, container string, command []string, stdout, stderr io.Writer) (int, error)
@@ -36,29 +118,200 @@ type KubernetesApiService interface {
 	CreateConcurrentTcpdumpCapturePod(targetPod string, containers []string, duration time.Duration) (string, error)
 	DeletePod(podName string) error
 	WaitForPodRunning(podName string, timeout time.Duration) error
+	PortForwardGETStream(pod string, podPort int, path string) (io.ReadCloser, error)
 	PortForwardGET(pod string, podPort int, path string) ([]byte, error)
 	RunEphemeralInTargetNetNS(targetPod, targetContainer string, command []string, privileged bool, timeout time.Duration) error
 	RunEphemeralInTargetNetNSWithOutput(targetPod, targetContainer string, command []string, privileged bool, timeout time.Duration, stdout, stderr io.Writer) error
 	StartEphemeralTcpdump(targetPod, targetContainer string, duration time.Duration, outPath string) error
 	StartEphemeralTcpdumpToLogs(targetPod, targetContainer string, duration time.Duration) (string, error)
+	StartEphemeralTcpdumpInContainerNetns(targetPod, targetContainer string, duration time.Duration) (string, error)
+	StartEphemeralTcpdumpToFiles(targetPod, targetContainer string, duration, rotate, keep time.Duration) (string, error)
+	ReleaseEphemeralCapture(targetPod, ecName string) error
+	CleanupEphemeral() error
+	CopyFileFromPod(pod, container, remotePath string, dst io.Writer) error
+	CopyFileToPod(pod, container, remotePath string, src io.Reader) error
 	PickSidecarContainer(podName string, containers []string) (string, error)
 	GetPodJSON(podName string) ([]byte, error)
+	GetNode(name string) ([]byte, error)
+	GetPodMetrics(podName string) ([]byte, error)
+	GetPodEvents(podName string, since time.Time) ([]byte, error)
+	GetNetworkPoliciesForPod(podName string) ([]byte, error)
+	ServerVersion() (string, error)
+	ListDeploymentPods(deployment, revision string) ([]string, error)
+	CheckEphemeralContainers(podName string) error
+	AdminGet(pod, container string, port int, path string) ([]byte, error)
+	ExecHTTP(pod, container string, port int, path string, dst io.Writer) error
+	AdminGetUDS(pod, container, socketPath, path string) ([]byte, error)
+	DetectMetricsEndpoint(podName string) (int, string, error)
+	DetectDebugPort(podName, container string) (int, error)
+	DescribeContainers(podName string) ([]ContainerInfo, error)
 }
 
 type KubernetesApiServiceImpl struct {
-	clientset  *kubernetes.Clientset
-	restConfig *rest.Config
-	namespace  string
+	clientset    kubernetes.Interface
+	restConfig   *rest.Config
+	namespace    string
+	ephemeralEnv []corev1.EnvVar
+	// image runs the ephemeral containers and debug pods (tcpdump, curl, netns).
+	image string
+	// fallbackImage runs AdminGet; it only needs wget, not the full netshoot toolset.
+	fallbackImage string
+	adminAuth     *AdminBasicAuth
+
+	// inflightMu guards the ephemeral containers (by pod) and debug pods this
+	// service started, for CleanupEphemeral.
+	inflightMu        sync.Mutex
+	inflightEphemeral map[string][]string
+	inflightDebugPods map[string]bool
 }
 
 var _ KubernetesApiService = &KubernetesApiServiceImpl{}
 
-func NewKubernetesApiService(clientset *kubernetes.Clientset, restConfig *rest.Config, namespace string) KubernetesApiService {
-	return &KubernetesApiServiceImpl{
+// ServiceOption customizes a KubernetesApiServiceImpl at construction time.
+type ServiceOption func(*KubernetesApiServiceImpl)
+
+// WithEphemeralEnv sets environment variables (e.g. HTTP_PROXY) on every
+// ephemeral container the service injects.
+func WithEphemeralEnv(env map[string]string) ServiceOption {
+	return func(k *KubernetesApiServiceImpl) {
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		k.ephemeralEnv = nil
+		for _, key := range keys {
+			k.ephemeralEnv = append(k.ephemeralEnv, corev1.EnvVar{Name: key, Value: env[key]})
+		}
+	}
+}
+
+// WithImage replaces NetshootImage for every ephemeral container and debug pod
+// the service creates. An empty image keeps the default.
+func WithImage(image string) ServiceOption {
+	return func(k *KubernetesApiServiceImpl) {
+		if image != "" {
+			k.image = image
+		}
+	}
+}
+
+// WithFallbackImage overrides the image used for AdminGet's HTTP fetch, leaving
+// the netshoot image for tcpdump and debugging untouched.
+func WithFallbackImage(image string) ServiceOption {
+	return func(k *KubernetesApiServiceImpl) {
+		k.fallbackImage = image
+	}
+}
+
+// Environment variables carrying the admin credentials into ephemeral
+// containers, resolved by the kubelet from the Secret.
+const (
+	AdminAuthUserEnv     = "XDSNAP_ADMIN_USER"
+	AdminAuthPasswordEnv = "XDSNAP_ADMIN_PASSWORD"
+)
+
+// AdminAuthCurlArgs expands, in an ephemeral container's shell, to curl's -u
+// option when the admin credentials are set and to nothing otherwise.
+const AdminAuthCurlArgs = `${` + AdminAuthUserEnv + `:+-u "$` + AdminAuthUserEnv + `:$` + AdminAuthPasswordEnv + `"}`
+
+// AdminBasicAuth holds Basic Auth credentials for an admin API that requires them.
+type AdminBasicAuth struct {
+	Username string
+	Password string
+	// Port is the admin port; credentials are never sent to other ports
+	// (e.g. an application's metrics endpoint).
+	Port int
+	// SecretNamespace and SecretName locate the Secret the credentials came
+	// from. Ephemeral containers in that namespace reference it through
+	// secretKeyRef rather than carrying the values in the pod spec.
+	SecretNamespace string
+	SecretName      string
+}
+
+// LoadAdminBasicAuth reads the username and password keys of a Secret, as
+// stored by the kubernetes.io/basic-auth type. Both keys must be present:
+// ephemeral containers reference them through secretKeyRef, and a missing key
+// would keep the container from starting.
+func LoadAdminBasicAuth(clientset kubernetes.Interface, namespace, name string, port int) (*AdminBasicAuth, error) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s: %w", namespace, name, err)
+	}
+	auth := &AdminBasicAuth{
+		Username:        string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password:        string(secret.Data[corev1.BasicAuthPasswordKey]),
+		Port:            port,
+		SecretNamespace: namespace,
+		SecretName:      name,
+	}
+	if auth.Username == "" {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, corev1.BasicAuthUsernameKey)
+	}
+	if _, ok := secret.Data[corev1.BasicAuthPasswordKey]; !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, corev1.BasicAuthPasswordKey)
+	}
+	return auth, nil
+}
+
+// WithAdminBasicAuth sends Basic Auth credentials with every admin request.
+// Ephemeral-container fallbacks only carry them when the Secret is in the
+// service's namespace, since a secretKeyRef cannot cross namespaces.
+func WithAdminBasicAuth(auth *AdminBasicAuth) ServiceOption {
+	return func(k *KubernetesApiServiceImpl) {
+		k.adminAuth = auth
+	}
+}
+
+// ephemeralAdminAuth reports whether ephemeral containers get the admin
+// credentials through AdminAuthUserEnv and AdminAuthPasswordEnv.
+func (k *KubernetesApiServiceImpl) ephemeralAdminAuth() bool {
+	return k.adminAuth != nil && k.adminAuth.SecretNamespace == k.namespace
+}
+
+func NewKubernetesApiService(clientset kubernetes.Interface, restConfig *rest.Config, namespace string, opts ...ServiceOption) KubernetesApiService {
+	k := &KubernetesApiServiceImpl{
 		clientset:  clientset,
 		restConfig: restConfig,
 		namespace:  namespace,
+		image:      NetshootImage,
+	}
+	for _, opt := range opts {
+		opt(k)
 	}
+	return k
+}
+
+// newEphemeralContainer builds the ephemeral container spec shared by all
+// ephemeral helpers, joining the namespaces of targetContainer.
+func (k *KubernetesApiServiceImpl) newEphemeralContainer(name, image, targetContainer string, command []string, privileged bool) corev1.EphemeralContainer {
+	env := k.ephemeralEnv
+	if k.ephemeralAdminAuth() {
+		env = append(append([]corev1.EnvVar(nil), env...),
+			secretEnvVar(AdminAuthUserEnv, k.adminAuth.SecretName, corev1.BasicAuthUsernameKey),
+			secretEnvVar(AdminAuthPasswordEnv, k.adminAuth.SecretName, corev1.BasicAuthPasswordKey),
+		)
+	}
+	return corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:            name,
+			Image:           image,
+			Command:         command,
+			Env:             env,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+		},
+		TargetContainerName: targetContainer,
+	}
+}
+
+func secretEnvVar(name, secret, key string) corev1.EnvVar {
+	return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+			Key:                  key,
+		},
+	}}
 }
 
 func (k *KubernetesApiServiceImpl) ExecuteCommand(pod string, container string, command []string, output io.Writer) (int, error) {
@@ -97,6 +350,10 @@ func (k *KubernetesApiServiceImpl) ExecuteCommand(pod string, container string,
 	return 0, nil
 }
 
+// ErrLogStreamOpen is returned by FetchContainerLogs when the log stream could
+// not be opened, e.g. because the container has not started yet.
+var ErrLogStreamOpen = errors.New("error opening log stream")
+
 func (k *KubernetesApiServiceImpl) FetchContainerLogs(ctx context.Context, podName string, containerName string, follow bool, out io.Writer) error {
 	req := k.clientset.CoreV1().Pods(k.namespace).GetLogs(podName, &corev1.PodLogOptions{
 		Container: containerName,
@@ -104,7 +361,7 @@ func (k *KubernetesApiServiceImpl) FetchContainerLogs(ctx context.Context, podNa
 	})
 	stream, err := req.Stream(ctx)
 	if err != nil {
-		return fmt.Errorf("error opening log stream: %w", err)
+		return fmt.Errorf("%w: %v", ErrLogStreamOpen, err)
 	}
 	defer stream.Close()
 	_, err = io.Copy(out, stream)
@@ -123,6 +380,26 @@ func (k *KubernetesApiServiceImpl) ListContainers(podName string) ([]string, err
 	return containers, nil
 }
 
+// DescribeContainers lists every container of a pod, including init and
+// ephemeral containers, with its detected role.
+func (k *KubernetesApiServiceImpl) DescribeContainers(podName string) ([]ContainerInfo, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	var infos []ContainerInfo
+	for _, c := range pod.Spec.InitContainers {
+		infos = append(infos, ContainerInfo{Name: c.Name, Image: c.Image, Role: RoleInit})
+	}
+	for _, c := range pod.Spec.Containers {
+		infos = append(infos, ContainerInfo{Name: c.Name, Image: c.Image, Role: DetectContainerRole(c.Name)})
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		infos = append(infos, ContainerInfo{Name: c.Name, Image: c.Image, Role: RoleEphemeral})
+	}
+	return infos, nil
+}
+
 func (k *KubernetesApiServiceImpl) InjectNetshootDebugContainer(targetPod string) error {
 	log.Printf("Injecting netshoot container into pod: %s", targetPod)
 	return fmt.Errorf("not supported: cannot inject containers into an existing pod")
@@ -163,7 +440,7 @@ func (k *KubernetesApiServiceImpl) CreateEphemeralNetshootPod(targetPod, contain
 			Containers: []corev1.Container{
 				{
 					Name:            container,
-					Image:           NetshootImage,
+					Image:           k.image,
 					Command:         command,
 					ImagePullPolicy: corev1.PullAlways,
 				},
@@ -175,11 +452,20 @@ func (k *KubernetesApiServiceImpl) CreateEphemeralNetshootPod(targetPod, contain
 	if err != nil {
 		return "", fmt.Errorf("failed to create ephemeral pod: %w", err)
 	}
+	k.inflightMu.Lock()
+	if k.inflightDebugPods == nil {
+		k.inflightDebugPods = map[string]bool{}
+	}
+	k.inflightDebugPods[pod.Name] = true
+	k.inflightMu.Unlock()
 
 	return pod.Name, nil
 }
 
 func (k *KubernetesApiServiceImpl) DeletePod(podName string) error {
+	k.inflightMu.Lock()
+	delete(k.inflightDebugPods, podName)
+	k.inflightMu.Unlock()
 	return k.clientset.CoreV1().Pods(k.namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})
 }
 
@@ -209,6 +495,51 @@ func (k *KubernetesApiServiceImpl) WaitForPodRunning(podName string, timeout tim
 }
 
 func (k *KubernetesApiServiceImpl) PortForwardGET(pod string, podPort int, path string) ([]byte, error) {
+	body, err := k.PortForwardGETStream(pod, podPort, path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read resp: %w", err)
+	}
+	return b, nil
+}
+
+// HTTPStatusError is returned for admin responses with an error status code.
+type HTTPStatusError struct {
+	Path       string
+	Status     string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	msg := e.Body
+	if msg == "" {
+		msg = e.Status
+	}
+	return fmt.Sprintf("GET %s -> %s (%d): %s", e.Path, e.Status, e.StatusCode, msg)
+}
+
+// portForwardBody closes the port-forward along with the response body.
+type portForwardBody struct {
+	io.ReadCloser
+	stopCh chan struct{}
+}
+
+func (b *portForwardBody) Close() error {
+	err := b.ReadCloser.Close()
+	close(b.stopCh)
+	return err
+}
+
+// PortForwardGETStream is PortForwardGET without buffering: the caller copies the
+// response body (e.g. a large /config_dump) wherever it needs to go, and closing
+// it tears down the port-forward. Error responses are still returned as errors.
+func (k *KubernetesApiServiceImpl) PortForwardGETStream(pod string, podPort int, path string) (io.ReadCloser, error) {
 	req := k.clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Namespace(k.namespace).
@@ -256,31 +587,86 @@ func (k *KubernetesApiServiceImpl) PortForwardGET(pod string, podPort int, path
 		if msg == "" {
 			msg = "timeout waiting for port-forward readiness"
 		}
-		return nil, fmt.Errorf(msg)
+		return nil, fmt.Errorf("%s", msg)
 	}
 
 	// make the request
 	url := fmt.Sprintf("http://127.0.0.1:%d%s", podPort, path)
-	resp, err := http.Get(url)
+	httpReq, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		close(stopCh)
 		return nil, fmt.Errorf("GET %s: %w", url, err)
 	}
-	defer resp.Body.Close()
-
-	b, err := io.ReadAll(resp.Body)
-	close(stopCh)
+	if k.adminAuth != nil && podPort == k.adminAuth.Port {
+		httpReq.SetBasicAuth(k.adminAuth.Username, k.adminAuth.Password)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("read resp: %w", err)
+		close(stopCh)
+		return nil, fmt.Errorf("GET %s: %w", url, err)
 	}
+
 	if resp.StatusCode >= 400 {
-		msg := strings.TrimSpace(string(b))
-		if msg == "" {
-			msg = resp.Status
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		close(stopCh)
+		return nil, &HTTPStatusError{Path: path, Status: resp.Status, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	return &portForwardBody{ReadCloser: resp.Body, stopCh: stopCh}, nil
+}
+
+// EphemeralUpdateAttempts bounds how often adding an ephemeral container is
+// tried when the pod update conflicts with another writer; the wait between
+// tries starts at EphemeralUpdateBackoff and doubles.
+var (
+	EphemeralUpdateAttempts = 3
+	EphemeralUpdateBackoff  = 500 * time.Millisecond
+)
+
+// ErrEphemeralUpdateConflict is returned, wrapped, when every attempt at adding
+// an ephemeral container lost a race with another update of the pod.
+var ErrEphemeralUpdateConflict = errors.New("ephemeral container update kept conflicting")
+
+// addEphemeralContainer appends ec to the pod's ephemeral containers. On a
+// conflict the pod is re-fetched and the container re-applied, up to
+// EphemeralUpdateAttempts times. A forbidden update is reported as an RBAC error.
+func (k *KubernetesApiServiceImpl) addEphemeralContainer(targetPod string, ec corev1.EphemeralContainer) error {
+	backoff := EphemeralUpdateBackoff
+	attempts := max(EphemeralUpdateAttempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
 		}
-		return nil, fmt.Errorf("GET %s -> %s (%d): %s", path, resp.Status, resp.StatusCode, msg)
+
+		pod, getErr := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("get pod: %w", getErr)
+		}
+		podCopy := pod.DeepCopy()
+		podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, ec)
+
+		_, err = k.clientset.CoreV1().
+			Pods(k.namespace).
+			UpdateEphemeralContainers(context.TODO(), targetPod, podCopy, metav1.UpdateOptions{})
+		switch {
+		case err == nil:
+			k.inflightMu.Lock()
+			if k.inflightEphemeral == nil {
+				k.inflightEphemeral = map[string][]string{}
+			}
+			k.inflightEphemeral[targetPod] = append(k.inflightEphemeral[targetPod], ec.Name)
+			k.inflightMu.Unlock()
+			return nil
+		case apierrors.IsForbidden(err):
+			return fmt.Errorf("rbac: update pods/ephemeralcontainers forbidden: %w", err)
+		case !apierrors.IsConflict(err):
+			return fmt.Errorf("update ephemeral containers: %w", err)
+		}
+		log.Printf("Pod %s changed while adding ephemeral container %s (attempt %d/%d)", targetPod, ec.Name, attempt, attempts)
 	}
-	return b, nil
+	return fmt.Errorf("update ephemeral containers: %w after %d attempts: %w", ErrEphemeralUpdateConflict, attempts, err)
 }
 
 // RunEphemeralInTargetNetNS adds an ephemeral container to the target pod that joins
@@ -300,36 +686,16 @@ func (k *KubernetesApiServiceImpl) RunEphemeralInTargetNetNS(
 		return fmt.Errorf("command must not be empty")
 	}
 
-	// 1) Get current pod
-	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("get pod: %w", err)
-	}
-
-	// 2) Build the ephemeral container
+	// 1) Build the ephemeral container
 	ecName := fmt.Sprintf("xdsnap-ephem-%d", time.Now().UnixNano())
-	ec := corev1.EphemeralContainer{
-		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-			Name:            ecName,
-			Image:           NetshootImage, // already defined in your code
-			Command:         command,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
-		},
-		TargetContainerName: targetContainer,
-	}
-
-	// 3) Append to a copy of the pod and update the subresource
-	podCopy := pod.DeepCopy()
-	podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, ec)
+	ec := k.newEphemeralContainer(ecName, k.image, targetContainer, command, privileged)
 
-	if _, err := k.clientset.CoreV1().
-		Pods(k.namespace).
-		UpdateEphemeralContainers(context.TODO(), targetPod, podCopy, metav1.UpdateOptions{}); err != nil {
-		return fmt.Errorf("update ephemeral containers: %w", err)
+	// 2) Append it to the current pod, retrying on update conflicts
+	if err := k.addEphemeralContainer(targetPod, ec); err != nil {
+		return err
 	}
 
-	// 4) Wait for the ephemeral container to run and terminate
+	// 3) Wait for the ephemeral container to run and terminate
 	deadline := time.Now().Add(timeout)
 	for {
 		if time.Now().After(deadline) {
@@ -381,6 +747,18 @@ func (k *KubernetesApiServiceImpl) RunEphemeralInTargetNetNSWithOutput(
 	timeout time.Duration,
 	stdout, stderr io.Writer,
 ) error {
+	return k.runEphemeralWithOutput(k.image, targetPod, targetContainer, command, privileged, timeout, stdout, stderr)
+}
+
+// runEphemeralWithOutput is RunEphemeralInTargetNetNSWithOutput with an explicit image.
+func (k *KubernetesApiServiceImpl) runEphemeralWithOutput(
+	image string,
+	targetPod, targetContainer string,
+	command []string,
+	privileged bool,
+	timeout time.Duration,
+	stdout, stderr io.Writer,
+) error {
 
 	if targetPod == "" || targetContainer == "" {
 		return fmt.Errorf("targetPod and targetContainer are required")
@@ -389,36 +767,16 @@ func (k *KubernetesApiServiceImpl) RunEphemeralInTargetNetNSWithOutput(
 		return fmt.Errorf("command must not be empty")
 	}
 
-	// 1. Fetch pod
-	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("get pod: %w", err)
-	}
-
-	// 2. Define ephemeral container
+	// 1. Define ephemeral container
 	ecName := fmt.Sprintf("xdsnap-ephem-%d", time.Now().UnixNano())
-	ec := corev1.EphemeralContainer{
-		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-			Name:            ecName,
-			Image:           NetshootImage,
-			Command:         command,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-			SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
-		},
-		TargetContainerName: targetContainer,
-	}
+	ec := k.newEphemeralContainer(ecName, image, targetContainer, command, privileged)
 
-	// 3. Patch ephemeral containers
-	podCopy := pod.DeepCopy()
-	podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, ec)
-
-	if _, err := k.clientset.CoreV1().
-		Pods(k.namespace).
-		UpdateEphemeralContainers(context.TODO(), targetPod, podCopy, metav1.UpdateOptions{}); err != nil {
-		return fmt.Errorf("update ephemeral containers: %w", err)
+	// 2. Patch ephemeral containers
+	if err := k.addEphemeralContainer(targetPod, ec); err != nil {
+		return err
 	}
 
-	// 4. Wait for container to terminate and fetch logs
+	// 3. Wait for container to terminate and fetch logs
 	deadline := time.Now().Add(timeout)
 	for {
 		if time.Now().After(deadline) {
@@ -490,7 +848,22 @@ func (k *KubernetesApiServiceImpl) StartEphemeralTcpdumpToLogs(
 	targetPod, targetContainer string,
 	duration time.Duration,
 ) (string, error) {
+	return k.startTcpdumpToLogs(targetPod, targetContainer, duration, "")
+}
+
+// StartEphemeralTcpdumpInContainerNetns is StartEphemeralTcpdumpToLogs for a
+// container whose process runs in a network namespace of its own: tcpdump is
+// run through nsenter in the netns of the target's PID 1 rather than the pod's.
+func (k *KubernetesApiServiceImpl) StartEphemeralTcpdumpInContainerNetns(
+	targetPod, targetContainer string,
+	duration time.Duration,
+) (string, error) {
+	return k.startTcpdumpToLogs(targetPod, targetContainer, duration, "nsenter -t 1 -n ")
+}
 
+// startTcpdumpToLogs runs tcpdump, prefixed by wrapper, in an ephemeral
+// container and waits for it to finish.
+func (k *KubernetesApiServiceImpl) startTcpdumpToLogs(targetPod, targetContainer string, duration time.Duration, wrapper string) (string, error) {
 	if targetPod == "" || targetContainer == "" {
 		return "", fmt.Errorf("targetPod and targetContainer are required")
 	}
@@ -501,39 +874,15 @@ func (k *KubernetesApiServiceImpl) StartEphemeralTcpdumpToLogs(
 	cmd := []string{
 		"sh", "-c",
 		// tcpdump -> write pcap to stdout; drop stderr noise; base64 encode; strip newlines; never fail the pipe.
-		fmt.Sprintf("timeout %ds tcpdump -i any -s0 -U -w - 2>/dev/null | base64 | tr -d '\\n\\r' || true", int(duration.Seconds())),
+		fmt.Sprintf("%stimeout %ds tcpdump -i any -s0 -U -w - 2>/dev/null | base64 | tr -d '\\n\\r' || true", wrapper, int(duration.Seconds())),
 	}
 
 	priv := true
 
-	// Fetch pod and append ephemeral container
-	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
-	if err != nil {
-		return "", fmt.Errorf("get pod: %w", err)
-	}
-
-	ec := corev1.EphemeralContainer{
-		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
-			Name:            ecName,
-			Image:           NetshootImage,
-			Command:         cmd,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-			SecurityContext: &corev1.SecurityContext{Privileged: &priv},
-		},
-		TargetContainerName: targetContainer,
-	}
-
-	podCopy := pod.DeepCopy()
-	podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, ec)
-
-	if _, err := k.clientset.CoreV1().
-		Pods(k.namespace).
-		UpdateEphemeralContainers(context.TODO(), targetPod, podCopy, metav1.UpdateOptions{}); err != nil {
-		// Surface lack of RBAC clearly to callers
-		if apierrors.IsForbidden(err) {
-			return "", fmt.Errorf("rbac: update pods/ephemeralcontainers forbidden: %w", err)
-		}
-		return "", fmt.Errorf("update ephemeral containers: %w", err)
+	// Append the ephemeral container; lack of RBAC is surfaced clearly to callers
+	ec := k.newEphemeralContainer(ecName, k.image, targetContainer, cmd, priv)
+	if err := k.addEphemeralContainer(targetPod, ec); err != nil {
+		return "", err
 	}
 
 	// Wait until the ephem container appears and then terminates (the timeout is implicit in tcpdump command)
@@ -566,6 +915,194 @@ func (k *KubernetesApiServiceImpl) StartEphemeralTcpdumpToLogs(
 	}
 }
 
+// StartEphemeralTcpdumpToFiles runs tcpdump in an ephemeral container that joins the
+// target container's netns and writes pcaps under EphemeralCaptureDir. When rotate
+// is non-zero tcpdump starts a new file every rotate interval (-G), and with keep
+// also non-zero, slices last written more than keep ago (rounded up to whole
+// minutes) are deleted while tcpdump runs. It returns the ephemeral container
+// name once tcpdump has finished; the container then lingers so the files can
+// be read with ExecuteCommand until ReleaseEphemeralCapture is called.
+func (k *KubernetesApiServiceImpl) StartEphemeralTcpdumpToFiles(
+	targetPod, targetContainer string,
+	duration, rotate, keep time.Duration,
+) (string, error) {
+
+	if targetPod == "" || targetContainer == "" {
+		return "", fmt.Errorf("targetPod and targetContainer are required")
+	}
+
+	output := EphemeralCaptureDir + "/xdsnap.pcap"
+	rotateArgs := ""
+	if rotate > 0 {
+		// strftime pattern gives every slice a unique, time-ordered name
+		output = EphemeralCaptureDir + "/xdsnap_%Y%m%d_%H%M%S.pcap"
+		rotateArgs = fmt.Sprintf("-G %d ", int(rotate.Seconds()))
+	}
+
+	tcpdump := fmt.Sprintf("timeout %ds tcpdump -i any -s0 %s-w '%s' 2>/dev/null; ", int(duration.Seconds()), rotateArgs, output)
+	if rotate > 0 && keep > 0 {
+		// prune old slices in the background while tcpdump runs, and once more after
+		prune := fmt.Sprintf("find %s -name '*.pcap' -mmin +%d -delete", EphemeralCaptureDir, int((keep+time.Minute-1)/time.Minute))
+		tcpdump = fmt.Sprintf("(while sleep %d; do %s; done) & pruner=$!; %skill $pruner; %s; ",
+			int(rotate.Seconds()), prune, tcpdump, prune)
+	}
+
+	ecName := fmt.Sprintf("xdsnap-tcpdump-%d", time.Now().UnixNano())
+	cmd := []string{
+		"sh", "-c",
+		fmt.Sprintf("mkdir -p %s && { %s}; "+
+			"touch %s; i=0; while [ ! -f %s ] && [ $i -lt %d ]; do sleep 1; i=$((i+1)); done",
+			EphemeralCaptureDir, tcpdump,
+			ephemeralCaptureDoneMarker, ephemeralCaptureCollectedMarker, ephemeralCaptureLingerSeconds),
+	}
+
+	priv := true
+
+	ec := k.newEphemeralContainer(ecName, k.image, targetContainer, cmd, priv)
+	if err := k.addEphemeralContainer(targetPod, ec); err != nil {
+		return "", err
+	}
+
+	// Wait until tcpdump exits and the done marker appears while the container is still running
+	deadline := time.Now().Add(duration + 60*time.Second) // allow image pull / spin-up slack
+	for {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("ephemeral container %q did not finish within %s", ecName, duration+60*time.Second)
+		}
+		cur, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
+		if err != nil {
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		var st *corev1.ContainerState
+		for i := range cur.Status.EphemeralContainerStatuses {
+			if cur.Status.EphemeralContainerStatuses[i].Name == ecName {
+				st = &cur.Status.EphemeralContainerStatuses[i].State
+				break
+			}
+		}
+		if st != nil && st.Terminated != nil {
+			return "", fmt.Errorf("ephemeral container %q exited before its capture files could be collected", ecName)
+		}
+		if st == nil || st.Running == nil {
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		if _, err := k.ExecuteCommand(targetPod, ecName, []string{"test", "-f", ephemeralCaptureDoneMarker}, io.Discard); err == nil {
+			return ecName, nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// ephemeralStopCommand releases a lingering capture container and terminates
+// every process but PID 1, ending tcpdump so its container can exit.
+var ephemeralStopCommand = []string{"sh", "-c", "touch " + ephemeralCaptureCollectedMarker + " 2>/dev/null; kill -TERM -1"}
+
+// CleanupEphemeral stops what this service left running, for an interrupted
+// capture: debug pods are deleted, and the still-running ephemeral containers
+// it added get their processes terminated. Ephemeral containers cannot be
+// removed from a pod, and one whose PID 1 is a plain sleep keeps running until
+// the sleep ends.
+func (k *KubernetesApiServiceImpl) CleanupEphemeral() error {
+	k.inflightMu.Lock()
+	ephemeral, debugPods := k.inflightEphemeral, k.inflightDebugPods
+	k.inflightEphemeral, k.inflightDebugPods = nil, nil
+	k.inflightMu.Unlock()
+
+	var errs []error
+	for name := range debugPods {
+		if err := k.DeletePod(name); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("delete debug pod %s: %w", name, err))
+		}
+	}
+	for podName, names := range ephemeral {
+		pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("get pod %s: %w", podName, err))
+			}
+			continue
+		}
+		for _, st := range pod.Status.EphemeralContainerStatuses {
+			if st.State.Running == nil || !slices.Contains(names, st.Name) {
+				continue
+			}
+			log.Printf("Stopping ephemeral container %s in pod %s", st.Name, podName)
+			if _, err := k.ExecuteCommand(podName, st.Name, ephemeralStopCommand, io.Discard); err != nil {
+				errs = append(errs, fmt.Errorf("stop ephemeral container %s in pod %s: %w", st.Name, podName, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseEphemeralCapture lets a lingering capture container started by
+// StartEphemeralTcpdumpToFiles exit once its files have been collected.
+func (k *KubernetesApiServiceImpl) ReleaseEphemeralCapture(targetPod, ecName string) error {
+	if _, err := k.ExecuteCommand(targetPod, ecName, []string{"touch", ephemeralCaptureCollectedMarker}, io.Discard); err != nil {
+		return fmt.Errorf("release ephemeral container %q: %w", ecName, err)
+	}
+	return nil
+}
+
+// CopyFileFromPod streams a file out of a running container by exec'ing `cat`,
+// writing the raw bytes directly into dst.
+func (k *KubernetesApiServiceImpl) CopyFileFromPod(pod, container, remotePath string, dst io.Writer) error {
+	if remotePath == "" {
+		return fmt.Errorf("remotePath is required")
+	}
+	if _, err := k.ExecuteCommand(pod, container, []string{"cat", remotePath}, dst); err != nil {
+		return fmt.Errorf("copy %s from %s/%s: %w", remotePath, pod, container, err)
+	}
+	return nil
+}
+
+// CopyFileToPod streams src into remotePath inside a running container by exec'ing
+// `cat` with stdin attached. The path is passed as a positional argument so it is
+// never interpreted by the shell.
+func (k *KubernetesApiServiceImpl) CopyFileToPod(pod, container, remotePath string, src io.Reader) error {
+	if remotePath == "" {
+		return fmt.Errorf("remotePath is required")
+	}
+
+	req := k.clientset.CoreV1().RESTClient().
+		Post().
+		Resource("pods").
+		Name(pod).
+		Namespace(k.namespace).
+		SubResource("exec").
+		Param("container", container).
+		Param("stdin", "true").
+		Param("stdout", "true").
+		Param("stderr", "true").
+		Param("tty", "false")
+
+	for _, arg := range []string{"sh", "-c", `cat > "$0"`, remotePath} {
+		req.Param("command", arg)
+	}
+
+	exec, err := remotecommand.NewSPDYExecutor(k.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	if err := exec.Stream(remotecommand.StreamOptions{
+		Stdin:  src,
+		Stdout: io.Discard,
+		Stderr: &stderr,
+		Tty:    false,
+	}); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("copy to %s in %s/%s: %s", remotePath, pod, container, msg)
+	}
+	return nil
+}
+
 func (k *KubernetesApiServiceImpl) CreatePrivilegedDebugPod(targetPod string, containerName string, command []string) (string, error) {
 	if len(command) == 0 {
 		return "", fmt.Errorf("no command specified")
@@ -634,18 +1171,14 @@ func (k *KubernetesApiServiceImpl) ExecuteCommandWithStderr(pod string, containe
 func (k *KubernetesApiServiceImpl) PickSidecarContainer(podName string, containers []string) (string, error) {
 	// 1️⃣ Check for Consul gateways first
 	for _, name := range containers {
-		if strings.HasPrefix(name, "api-gateway") ||
-			strings.HasPrefix(name, "mesh-gateway") ||
-			strings.HasPrefix(name, "ingress-gateway") ||
-			strings.HasPrefix(name, "terminating-gateway") {
+		if DetectContainerRole(name) == RoleGateway {
 			log.Printf("Detected gateway container for pod %s: %s", podName, name)
 			return name, nil
 		}
 	}
 
 	// 2️⃣ Then check for workload dataplanes or sidecars
-	candidates := []string{"consul-dataplane", "envoy-sidecar"}
-	for _, c := range candidates {
+	for _, c := range sidecarContainerNames {
 		if ok, _ := k.ContainerExists(podName, c); ok {
 			log.Printf("Detected dataplane container for pod %s: %s", podName, c)
 			return c, nil
@@ -675,6 +1208,396 @@ func (k *KubernetesApiServiceImpl) GetPodJSON(podName string) ([]byte, error) {
 	return json.MarshalIndent(pod, "", "  ")
 }
 
+// GetNode returns the node object (conditions, allocatable, taints) as indented JSON.
+func (k *KubernetesApiServiceImpl) GetNode(name string) ([]byte, error) {
+	node, err := k.clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+	return json.MarshalIndent(node, "", "  ")
+}
+
+// GetPodEvents returns the pod's events as an indented JSON array, oldest first.
+// Events last seen before since are dropped; a zero since keeps them all.
+func (k *KubernetesApiServiceImpl) GetPodEvents(podName string, since time.Time) ([]byte, error) {
+	list, err := k.clientset.CoreV1().Events(k.namespace).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Pod,involvedObject.name=%s", podName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	events := []corev1.Event{}
+	for _, e := range list.Items {
+		if !since.IsZero() && eventLastSeen(e).Before(since) {
+			continue
+		}
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool { return eventLastSeen(events[i]).Before(eventLastSeen(events[j])) })
+	return json.MarshalIndent(events, "", "  ")
+}
+
+// eventLastSeen is the event's lastTimestamp, falling back to eventTime and
+// firstTimestamp for events recorded through the events.k8s.io API.
+func eventLastSeen(e corev1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+// GetNetworkPoliciesForPod returns, as an indented JSON array, the
+// NetworkPolicies in the pod's namespace whose podSelector matches the pod's
+// labels. An empty podSelector selects every pod in the namespace.
+func (k *KubernetesApiServiceImpl) GetNetworkPoliciesForPod(podName string) ([]byte, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	list, err := k.clientset.NetworkingV1().NetworkPolicies(k.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network policies: %w", err)
+	}
+
+	policies := []networkingv1.NetworkPolicy{}
+	for _, np := range list.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&np.Spec.PodSelector)
+		if err != nil {
+			log.Printf("Skipping network policy %s: invalid podSelector: %v", np.Name, err)
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			// Server-side apply bookkeeping only adds noise to the bundle.
+			np.ManagedFields = nil
+			policies = append(policies, np)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return json.MarshalIndent(policies, "", "  ")
+}
+
+// ServerVersion returns the Kubernetes API server's git version (e.g. v1.29.4).
+func (k *KubernetesApiServiceImpl) ServerVersion() (string, error) {
+	info, err := k.clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %w", err)
+	}
+	return info.GitVersion, nil
+}
+
+// ErrMetricsUnavailable is returned by GetPodMetrics when the metrics.k8s.io API
+// is not served (metrics-server is not installed) or has no sample for the pod yet.
+var ErrMetricsUnavailable = errors.New("metrics.k8s.io API unavailable")
+
+// GetPodMetrics returns the pod's PodMetrics object (per-container CPU and memory
+// usage) from metrics-server, fetched as raw JSON through the discovery client.
+func (k *KubernetesApiServiceImpl) GetPodMetrics(podName string) ([]byte, error) {
+	path := fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/namespaces/%s/pods/%s", k.namespace, podName)
+	data, err := k.clientset.Discovery().RESTClient().Get().AbsPath(path).DoRaw(context.TODO())
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsServiceUnavailable(err) {
+			return nil, ErrMetricsUnavailable
+		}
+		return nil, fmt.Errorf("failed to get pod metrics: %w", err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return data, nil
+	}
+	return out.Bytes(), nil
+}
+
+// ListDeploymentPods returns the pods owned by a Deployment's ReplicaSets. revision
+// selects the newest ReplicaSet ("new"), every older one ("old"), or all of them,
+// ordered by the deployment.kubernetes.io/revision annotation. This lets a capture
+// compare Envoy state across a canary or blue/green rollout.
+func (k *KubernetesApiServiceImpl) ListDeploymentPods(deployment, revision string) ([]string, error) {
+	dep, err := k.clientset.AppsV1().Deployments(k.namespace).Get(context.TODO(), deployment, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get deployment: %w", err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("deployment selector: %w", err)
+	}
+
+	rsList, err := k.clientset.AppsV1().ReplicaSets(k.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("list replicasets: %w", err)
+	}
+
+	revisions := map[string]int{}
+	newest := -1
+	for _, rs := range rsList.Items {
+		owner := metav1.GetControllerOf(&rs)
+		if owner == nil || owner.UID != dep.UID {
+			continue
+		}
+		rev, err := strconv.Atoi(rs.Annotations[deploymentRevisionAnnotation])
+		if err != nil {
+			continue
+		}
+		revisions[rs.Name] = rev
+		if rev > newest {
+			newest = rev
+		}
+	}
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("no replicasets found for deployment %s", deployment)
+	}
+
+	selected := map[string]bool{}
+	for name, rev := range revisions {
+		switch revision {
+		case RevisionNew:
+			selected[name] = rev == newest
+		case RevisionOld:
+			selected[name] = rev != newest
+		case RevisionAll, "":
+			selected[name] = true
+		default:
+			return nil, fmt.Errorf("unknown revision %q (expected %s, %s or %s)", revision, RevisionNew, RevisionOld, RevisionAll)
+		}
+	}
+
+	pods, err := k.clientset.CoreV1().Pods(k.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+
+	var names []string
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "ReplicaSet" || !selected[owner.Name] {
+			continue
+		}
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// AdminGet performs an HTTP GET against 127.0.0.1:port inside the netns of the
+// given container and returns the body; see ExecHTTP.
+func (k *KubernetesApiServiceImpl) AdminGet(pod, container string, port int, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := k.ExecHTTP(pod, container, port, path, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// execHTTPLinger is how long the ExecHTTP helper container stays alive. It
+// cannot be stopped explicitly without signalling the target's processes.
+const execHTTPLinger = 120 * time.Second
+
+// ExecHTTP performs an HTTP GET against 127.0.0.1:port inside the netns of the
+// given container and streams the body into dst. An idle ephemeral container is
+// started and wget is exec'd into it with a plain argv (no shell), so the path
+// is never subject to quoting or injection, and the body travels over the exec
+// stream byte for byte rather than through container logs, which the kubelet
+// rotates and line-splits.
+func (k *KubernetesApiServiceImpl) ExecHTTP(pod, container string, port int, path string, dst io.Writer) error {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, path)
+
+	image := k.image
+	if k.fallbackImage != "" {
+		image = k.fallbackImage
+	}
+
+	ecName := fmt.Sprintf("xdsnap-http-%d", time.Now().UnixNano())
+	command := []string{"sleep", strconv.Itoa(int(execHTTPLinger.Seconds()))}
+	if err := k.startEphemeralAndWait(pod, k.newEphemeralContainer(ecName, image, container, command, false), 60*time.Second); err != nil {
+		return fmt.Errorf("admin GET %s: %w", path, err)
+	}
+
+	// The exec argv is not persisted in the pod spec, so credentials can be
+	// passed directly.
+	wget := []string{"wget", "-q", "-O", "-"}
+	if k.adminAuth != nil && port == k.adminAuth.Port {
+		token := base64.StdEncoding.EncodeToString([]byte(k.adminAuth.Username + ":" + k.adminAuth.Password))
+		wget = append(wget, "--header", "Authorization: Basic "+token)
+	}
+	var stderr bytes.Buffer
+	if _, err := k.ExecuteCommandWithStderr(pod, ecName, append(wget, url), dst, &stderr); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("admin GET %s: %w: %s", path, err, msg)
+		}
+		return fmt.Errorf("admin GET %s: %w", path, err)
+	}
+	return nil
+}
+
+// startEphemeralAndWait adds ec to the pod and waits until it is running.
+func (k *KubernetesApiServiceImpl) startEphemeralAndWait(targetPod string, ec corev1.EphemeralContainer, timeout time.Duration) error {
+	if err := k.addEphemeralContainer(targetPod, ec); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		cur, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), targetPod, metav1.GetOptions{})
+		if err == nil {
+			for _, st := range cur.Status.EphemeralContainerStatuses {
+				if st.Name != ec.Name {
+					continue
+				}
+				if st.State.Running != nil {
+					return nil
+				}
+				if st.State.Terminated != nil {
+					return fmt.Errorf("ephemeral container %q exited: %s", ec.Name, st.State.Terminated.Reason)
+				}
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("ephemeral container %q did not start within %s", ec.Name, timeout)
+}
+
+// AdminGetUDS performs an HTTP GET against an admin API that listens only on a
+// Unix domain socket in the given container. The ephemeral container targets
+// that container, so the socket is reached through /proc/1/root; a path starting
+// with "@" is treated as an abstract socket, which lives in the shared netns.
+func (k *KubernetesApiServiceImpl) AdminGetUDS(pod, container, socketPath, path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	socketArgs := []string{"--unix-socket", "/proc/1/root" + socketPath}
+	if strings.HasPrefix(socketPath, "@") {
+		socketArgs = []string{"--abstract-unix-socket", strings.TrimPrefix(socketPath, "@")}
+	}
+	command := append([]string{"curl", "-sS", "--fail"}, socketArgs...)
+	command = append(command, "http://localhost"+path)
+	if k.ephemeralAdminAuth() {
+		// The arguments stay positional so the path is never parsed by the shell.
+		command = append([]string{"sh", "-c", `exec curl ` + AdminAuthCurlArgs + ` "$@"`, "sh"}, command[1:]...)
+	}
+
+	var buf bytes.Buffer
+	if err := k.runEphemeralWithOutput(k.image, pod, container, command, false, 15*time.Second, &buf, nil); err != nil {
+		return nil, fmt.Errorf("admin GET %s over %s: %w", path, socketPath, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DetectMetricsEndpoint finds the pod's Prometheus scrape port and path from the
+// prometheus.io/port and prometheus.io/path annotations, then from a container
+// port named "metrics" or "prometheus", falling back to DefaultMetricsPort.
+func (k *KubernetesApiServiceImpl) DetectMetricsEndpoint(podName string) (int, string, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	path := "/metrics"
+	if p := pod.Annotations["prometheus.io/path"]; p != "" {
+		path = p
+	}
+	if p, err := strconv.Atoi(pod.Annotations["prometheus.io/port"]); err == nil && p > 0 {
+		return p, path, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, port := range c.Ports {
+			if port.Name == "metrics" || port.Name == "prometheus" {
+				return int(port.ContainerPort), path, nil
+			}
+		}
+	}
+	return DefaultMetricsPort, path, nil
+}
+
+// debugPortNames are container port names that conventionally serve pprof.
+var debugPortNames = []string{"debug", "pprof", "http-debug"}
+
+// DetectDebugPort finds the pprof/debug port of a container: a port named
+// "debug", "pprof" or "http-debug", or else the port of a command-line flag
+// mentioning pprof or debug whose value is an address (e.g. -pprof-addr=:6060).
+func (k *KubernetesApiServiceImpl) DetectDebugPort(podName, container string) (int, error) {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pod: %w", err)
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name != container {
+			continue
+		}
+		for _, port := range c.Ports {
+			for _, name := range debugPortNames {
+				if port.Name == name {
+					return int(port.ContainerPort), nil
+				}
+			}
+		}
+		args := append(append([]string{}, c.Command...), c.Args...)
+		for i, arg := range args {
+			flag, value, ok := strings.Cut(arg, "=")
+			if !ok && i+1 < len(args) {
+				value = args[i+1]
+			}
+			lower := strings.ToLower(flag)
+			if !strings.HasPrefix(lower, "-") || !(strings.Contains(lower, "pprof") || strings.Contains(lower, "debug")) {
+				continue
+			}
+			if _, portStr, err := net.SplitHostPort(value); err == nil {
+				if p, err := strconv.Atoi(portStr); err == nil && p > 0 {
+					return p, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("container %s exposes no debug port (no port named %s and no pprof/debug address flag)", container, strings.Join(debugPortNames, ", "))
+	}
+	return 0, fmt.Errorf("container %s not found in pod %s", container, podName)
+}
+
+// ErrEphemeralUnavailable is wrapped by CheckEphemeralContainers when ephemeral
+// containers cannot be used in the namespace: RBAC forbids updating
+// pods/ephemeralcontainers or the cluster does not serve the subresource.
+var ErrEphemeralUnavailable = errors.New("ephemeral containers unavailable")
+
+// CheckEphemeralContainers verifies that ephemeral containers can be added to the
+// pod by issuing a server-side dry-run update of the ephemeralcontainers
+// subresource. It returns nil when supported, an error wrapping
+// ErrEphemeralUnavailable when they cannot be used (feature/subresource
+// disabled or RBAC forbidden), and any other error when the check itself
+// failed, e.g. on a timeout.
+func (k *KubernetesApiServiceImpl) CheckEphemeralContainers(podName string) error {
+	pod, err := k.clientset.CoreV1().Pods(k.namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get pod: %w", err)
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("pod %s has no containers", podName)
+	}
+
+	probe := k.newEphemeralContainer(fmt.Sprintf("xdsnap-probe-%d", time.Now().UnixNano()), k.image, pod.Spec.Containers[0].Name, []string{"true"}, false)
+	podCopy := pod.DeepCopy()
+	podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, probe)
+
+	_, err = k.clientset.CoreV1().
+		Pods(k.namespace).
+		UpdateEphemeralContainers(context.TODO(), podName, podCopy, metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}})
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsForbidden(err):
+		return fmt.Errorf("%w: rbac: update pods/ephemeralcontainers forbidden: %w", ErrEphemeralUnavailable, err)
+	case apierrors.IsNotFound(err), apierrors.IsMethodNotSupported(err):
+		return fmt.Errorf("%w: not enabled in this cluster: %w", ErrEphemeralUnavailable, err)
+	default:
+		return fmt.Errorf("ephemeral containers dry-run failed: %w", err)
+	}
+}
+
 func newHostPathType(t corev1.HostPathType) *corev1.HostPathType {
 	return &t
 }
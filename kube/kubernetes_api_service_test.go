@@ -0,0 +1,205 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// ephemeralUpdateClient returns a service whose ephemeral container updates
+// answer with responses in turn, the last one repeating, and a counter of the
+// update calls made.
+func ephemeralUpdateClient(responses ...error) (*KubernetesApiServiceImpl, *int) {
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-7d9f", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	})
+	calls := 0
+	clientset.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "ephemeralcontainers" {
+			return false, nil, nil
+		}
+		err := responses[min(calls, len(responses)-1)]
+		calls++
+		if err != nil {
+			return true, nil, err
+		}
+		return true, action.(k8stesting.UpdateAction).GetObject(), nil
+	})
+	return NewKubernetesApiService(clientset, nil, "default").(*KubernetesApiServiceImpl), &calls
+}
+
+func TestAddEphemeralContainerRetries(t *testing.T) {
+	defer func(attempts int, backoff time.Duration) {
+		EphemeralUpdateAttempts, EphemeralUpdateBackoff = attempts, backoff
+	}(EphemeralUpdateAttempts, EphemeralUpdateBackoff)
+	EphemeralUpdateAttempts, EphemeralUpdateBackoff = 3, time.Millisecond
+
+	pods := schema.GroupResource{Resource: "pods"}
+	conflict := apierrors.NewConflict(pods, "web-7d9f", errors.New("the object has been modified"))
+	forbidden := apierrors.NewForbidden(pods, "web-7d9f", errors.New("cannot update pods/ephemeralcontainers"))
+
+	tests := []struct {
+		name      string
+		responses []error
+		wantCalls int
+		// wantConflict and wantForbidden tell which error the result wraps.
+		wantConflict  bool
+		wantForbidden bool
+	}{
+		{
+			name:      "succeeds after a conflict",
+			responses: []error{conflict, nil},
+			wantCalls: 2,
+		},
+		{
+			// The forbidden answer queued after the conflicts is never reached.
+			name:         "conflicts exhaust the attempts",
+			responses:    []error{conflict, conflict, conflict, forbidden},
+			wantCalls:    3,
+			wantConflict: true,
+		},
+		{
+			name:          "forbidden is not retried",
+			responses:     []error{forbidden},
+			wantCalls:     1,
+			wantForbidden: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := ephemeralUpdateClient(tt.responses...)
+			err := k.addEphemeralContainer("web-7d9f", corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "xdsnap-test"}})
+
+			if *calls != tt.wantCalls {
+				t.Errorf("update calls = %d, want %d", *calls, tt.wantCalls)
+			}
+			if got := errors.Is(err, ErrEphemeralUpdateConflict); got != tt.wantConflict {
+				t.Errorf("errors.Is(%v, ErrEphemeralUpdateConflict) = %v, want %v", err, got, tt.wantConflict)
+			}
+			if got := apierrors.IsForbidden(err); got != tt.wantForbidden {
+				t.Errorf("IsForbidden(%v) = %v, want %v", err, got, tt.wantForbidden)
+			}
+			if err == nil && len(k.inflightEphemeral["web-7d9f"]) != 1 {
+				t.Errorf("in-flight ephemeral containers = %v, want the added one tracked", k.inflightEphemeral)
+			}
+		})
+	}
+}
+
+func TestGetNetworkPoliciesForPod(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-7d9f", Namespace: "default", Labels: map[string]string{"app": "web"}}},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:          "web-ingress",
+				Namespace:     "default",
+				ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}},
+			},
+			Spec: networkingv1.NetworkPolicySpec{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+		},
+		&networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-ingress", Namespace: "default"},
+			Spec:       networkingv1.NetworkPolicySpec{PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+		},
+	)
+	k := NewKubernetesApiService(clientset, nil, "default")
+
+	data, err := k.GetNetworkPoliciesForPod("web-7d9f")
+	if err != nil {
+		t.Fatalf("GetNetworkPoliciesForPod: %v", err)
+	}
+	var policies []networkingv1.NetworkPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(policies) != 1 || policies[0].Name != "web-ingress" {
+		t.Fatalf("policies = %s, want only web-ingress", data)
+	}
+	if policies[0].ManagedFields != nil {
+		t.Errorf("managedFields kept: %v", policies[0].ManagedFields)
+	}
+}
+
+func TestLoadAdminBasicAuth(t *testing.T) {
+	secret := func(name string, data map[string]string) *corev1.Secret {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "consul"}, Data: map[string][]byte{}}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+	clientset := fake.NewSimpleClientset(
+		secret("envoy-admin", map[string]string{"username": "admin", "password": "s3cret"}),
+		secret("no-username", map[string]string{"password": "s3cret"}),
+		secret("no-password", map[string]string{"username": "admin"}),
+	)
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{name: "both keys", secret: "envoy-admin"},
+		{name: "missing username", secret: "no-username", wantErr: `no "username" key`},
+		{name: "missing password", secret: "no-password", wantErr: `no "password" key`},
+		{name: "missing secret", secret: "absent", wantErr: "get secret consul/absent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := LoadAdminBasicAuth(clientset, "consul", tt.secret, 19000)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadAdminBasicAuth: %v", err)
+			}
+			want := AdminBasicAuth{Username: "admin", Password: "s3cret", Port: 19000, SecretNamespace: "consul", SecretName: tt.secret}
+			if *auth != want {
+				t.Errorf("auth = %+v, want %+v", *auth, want)
+			}
+		})
+	}
+}
+
+func TestCheckEphemeralContainers(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		name            string
+		response        error
+		wantErr         bool
+		wantUnavailable bool
+	}{
+		{name: "supported", response: nil},
+		{name: "forbidden", response: apierrors.NewForbidden(pods, "web-7d9f", errors.New("rbac")), wantErr: true, wantUnavailable: true},
+		{name: "not served", response: apierrors.NewNotFound(pods, "ephemeralcontainers"), wantErr: true, wantUnavailable: true},
+		{name: "conflict", response: apierrors.NewConflict(pods, "web-7d9f", errors.New("modified")), wantErr: true},
+		{name: "timeout", response: apierrors.NewTimeoutError("dry-run", 1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, _ := ephemeralUpdateClient(tt.response)
+			err := k.CheckEphemeralContainers("web-7d9f")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckEphemeralContainers() = %v, want error %v", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrEphemeralUnavailable); got != tt.wantUnavailable {
+				t.Errorf("errors.Is(%v, ErrEphemeralUnavailable) = %v, want %v", err, got, tt.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// fakeAdminKube answers the admin calls of an EnvoyAdminClient from scripted
+// responses, one per call; the last response repeats. Other
+// KubernetesApiService methods are not implemented.
+type fakeAdminKube struct {
+	kube.KubernetesApiService
+
+	portForward []fakeResponse
+	exec        []fakeResponse
+
+	portForwardCalls int
+	execCalls        int
+}
+
+type fakeResponse struct {
+	body string
+	err  error
+}
+
+func (f *fakeAdminKube) PortForwardGET(pod string, podPort int, path string) ([]byte, error) {
+	f.portForwardCalls++
+	return next(f.portForward, f.portForwardCalls)
+}
+
+func (f *fakeAdminKube) AdminGet(pod, container string, port int, path string) ([]byte, error) {
+	f.execCalls++
+	return next(f.exec, f.execCalls)
+}
+
+func (f *fakeAdminKube) PortForwardGETStream(pod string, podPort int, path string) (io.ReadCloser, error) {
+	b, err := f.PortForwardGET(pod, podPort, path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(b))), nil
+}
+
+func (f *fakeAdminKube) ExecHTTP(pod, container string, port int, path string, dst io.Writer) error {
+	b, err := f.AdminGet(pod, container, port, path)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(b)
+	return err
+}
+
+func next(responses []fakeResponse, call int) ([]byte, error) {
+	if len(responses) == 0 {
+		return nil, errors.New("unexpected call")
+	}
+	r := responses[min(call, len(responses))-1]
+	if r.err != nil {
+		return nil, r.err
+	}
+	return []byte(r.body), nil
+}
+
+func TestEnvoyAdminClientGet(t *testing.T) {
+	notFound := &kube.HTTPStatusError{Path: "/init_dump", Status: "404 Not Found", StatusCode: http.StatusNotFound}
+	pfDown := errors.New("port-forward: connection refused")
+
+	tests := []struct {
+		name     string
+		endpoint string
+		// budget is the retry budget; 0 means unlimited.
+		budget      int
+		portForward []fakeResponse
+		exec        []fakeResponse
+		// gets is how many times the endpoint is fetched with the same client.
+		gets int
+
+		wantBody             string
+		wantErr              string
+		wantPortForwardCalls int
+		wantExecCalls        int
+	}{
+		{
+			name:                 "404 returns without retry or fallback",
+			endpoint:             "/init_dump",
+			portForward:          []fakeResponse{{err: notFound}},
+			exec:                 []fakeResponse{{body: "{}"}},
+			wantErr:              "404",
+			wantPortForwardCalls: 1,
+		},
+		{
+			name:                 "response failing the shape check is retried",
+			endpoint:             "/certs",
+			portForward:          []fakeResponse{{body: "<html>starting</html>"}, {body: `{"certificates":[]}`}},
+			wantBody:             `{"certificates":[]}`,
+			wantPortForwardCalls: 2,
+		},
+		{
+			name:                 "falls back to exec when port-forward fails",
+			endpoint:             "/listeners",
+			portForward:          []fakeResponse{{err: pfDown}},
+			exec:                 []fakeResponse{{body: "public_listener::10.0.0.1:20000"}},
+			wantBody:             "public_listener::10.0.0.1:20000",
+			wantPortForwardCalls: 3,
+			wantExecCalls:        1,
+		},
+		{
+			name:                 "exhausted budget stops retries and skips the fallback",
+			endpoint:             "/listeners",
+			budget:               1,
+			portForward:          []fakeResponse{{err: pfDown}},
+			exec:                 []fakeResponse{{body: "public_listener::10.0.0.1:20000"}},
+			wantErr:              "retry budget is exhausted",
+			wantPortForwardCalls: 2,
+		},
+		{
+			name:                 "port-forward is skipped after the fallback worked",
+			endpoint:             "/listeners",
+			portForward:          []fakeResponse{{err: pfDown}},
+			exec:                 []fakeResponse{{body: "public_listener::10.0.0.1:20000"}},
+			gets:                 3,
+			wantBody:             "public_listener::10.0.0.1:20000",
+			wantPortForwardCalls: 3,
+			wantExecCalls:        3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAdminKube{portForward: tt.portForward, exec: tt.exec}
+			c := NewEnvoyAdminClient(fake, "web-7d9f", "envoy-sidecar", defaultAdminPort)
+			c.Retries = 3
+			c.RetryDelay = 0
+			c.SkipPortForwardAfterFallback = true
+			c.Budget = NewRetryBudget(tt.budget)
+
+			var body []byte
+			var err error
+			for i := 0; i < max(tt.gets, 1); i++ {
+				body, err = c.Get(tt.endpoint)
+			}
+
+			switch {
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Get() error = %v, want it to contain %q", err, tt.wantErr)
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Get() error = %v", err)
+			case string(body) != tt.wantBody:
+				t.Errorf("Get() = %q, want %q", body, tt.wantBody)
+			}
+			if fake.portForwardCalls != tt.wantPortForwardCalls {
+				t.Errorf("port-forward calls = %d, want %d", fake.portForwardCalls, tt.wantPortForwardCalls)
+			}
+			if fake.execCalls != tt.wantExecCalls {
+				t.Errorf("exec fallback calls = %d, want %d", fake.execCalls, tt.wantExecCalls)
+			}
+		})
+	}
+}
+
+func TestEnvoyAdminClientGetWithoutSkipRetriesPortForward(t *testing.T) {
+	fake := &fakeAdminKube{
+		portForward: []fakeResponse{{err: errors.New("port-forward: connection refused")}},
+		exec:        []fakeResponse{{body: "public_listener::10.0.0.1:20000"}},
+	}
+	c := NewEnvoyAdminClient(fake, "web-7d9f", "envoy-sidecar", defaultAdminPort)
+	c.Retries = 2
+	c.RetryDelay = 0
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get("/listeners"); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if fake.portForwardCalls != 4 {
+		t.Errorf("port-forward calls = %d, want 4: every request retries without --skip-port-forward-after-fallback", fake.portForwardCalls)
+	}
+}
+
+func TestEnvoyAdminClientStream(t *testing.T) {
+	pfDown := errors.New("port-forward: connection refused")
+	tests := []struct {
+		name          string
+		budgetSpent   bool
+		wantErr       bool
+		wantExecCalls int
+	}{
+		{name: "falls back to exec", wantExecCalls: 1},
+		{name: "no fallback once the budget is spent", budgetSpent: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeAdminKube{
+				portForward: []fakeResponse{{err: pfDown}},
+				exec:        []fakeResponse{{body: `{"configs":[]}`}},
+			}
+			c := NewEnvoyAdminClient(fake, "web-7d9f", "envoy-sidecar", defaultAdminPort)
+			if tt.budgetSpent {
+				c.Budget = NewRetryBudget(1)
+				c.Budget.take(c.Pod)
+			}
+			path := filepath.Join(t.TempDir(), "config_dump.json")
+
+			_, err := c.Stream("/config_dump", path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Stream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fake.execCalls != tt.wantExecCalls {
+				t.Errorf("exec fallback calls = %d, want %d", fake.execCalls, tt.wantExecCalls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got, _ := os.ReadFile(path); string(got) != `{"configs":[]}` {
+				t.Errorf("streamed file = %q", got)
+			}
+		})
+	}
+}
+
+func TestEnvoyAdminClientSetLogLevelDirect(t *testing.T) {
+	var method, path, rawQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, rawQuery = r.Method, r.URL.Path, r.URL.RawQuery
+	}))
+	defer srv.Close()
+
+	c := NewEnvoyAdminClient(nil, "web-7d9f", "app", defaultAdminPort)
+	c.URL = srv.URL
+	c.PathPrefix = "/envoy"
+	if err := c.SetLogLevel("debug&paths=all"); err != nil {
+		t.Fatalf("SetLogLevel: %v", err)
+	}
+
+	if method != http.MethodPost {
+		t.Errorf("method = %s, want POST", method)
+	}
+	if path != "/envoy/logging" {
+		t.Errorf("path = %s, want /envoy/logging", path)
+	}
+	if rawQuery != "level=debug%26paths%3Dall" {
+		t.Errorf("query = %s, want the level escaped as a single parameter", rawQuery)
+	}
+}
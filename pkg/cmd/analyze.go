@@ -148,7 +148,7 @@ func NewAnalyzeCommand(_ genericclioptions.IOStreams) *cobra.Command {
 	opts := &AnalyzeOptions{}
 
 	cmd := &cobra.Command{
-		Use:   "analyze [snapshot.tar.gz]",
+		Use:   "analyze [snapshot.tar.gz | snapshot-dir]",
 		Short: "Analyze a captured xDSnap snapshot offline and emit findings/reports",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -186,9 +186,13 @@ func runAnalyze(opts AnalyzeOptions) error {
 		return fmt.Errorf("create output dir: %w", err)
 	}
 
-	extractedDir := filepath.Join(outputDir, "bundle")
-	if err := extractTarGz(opts.BundlePath, extractedDir); err != nil {
-		return fmt.Errorf("extract snapshot: %w", err)
+	// An --output-dir-only snapshot directory is analyzed in place.
+	extractedDir := opts.BundlePath
+	if fi, err := os.Stat(opts.BundlePath); err != nil || !fi.IsDir() {
+		extractedDir = filepath.Join(outputDir, "bundle")
+		if err := extractTarGz(opts.BundlePath, extractedDir); err != nil {
+			return fmt.Errorf("extract snapshot: %w", err)
+		}
 	}
 
 	bundle, err := loadAnalyzeBundle(opts.BundlePath, extractedDir)
@@ -314,10 +318,13 @@ func loadAnalyzeBundle(bundlePath, root string) (*AnalyzeBundle, error) {
 		}
 		rel = filepath.ToSlash(rel)
 
-		data, err := os.ReadFile(path)
+		data, err := readBundleFile(path)
 		if err != nil {
 			return nil
 		}
+		// Compressed artifacts (e.g. x-logs.txt.gz) are analyzed under their
+		// uncompressed name.
+		rel = strings.TrimSuffix(rel, ".gz")
 		content := string(data)
 		b.Files[rel] = content
 
@@ -348,6 +355,29 @@ func loadAnalyzeBundle(bundlePath, root string) (*AnalyzeBundle, error) {
 	return b, nil
 }
 
+// readBundleFile reads a snapshot file, transparently decompressing .gz files.
+func readBundleFile(path string) ([]byte, error) {
+	if !strings.HasSuffix(path, ".gz") {
+		return os.ReadFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return gunzipAll(f)
+}
+
+// gunzipAll decompresses a gzip stream.
+func gunzipAll(r io.Reader) ([]byte, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	return io.ReadAll(gzr)
+}
+
 func inferPodNameFromBundle(bundlePath string) string {
 	base := filepath.Base(bundlePath)
 	base = strings.TrimSuffix(base, ".tar.gz")
@@ -368,6 +398,7 @@ func runRules(bundle *AnalyzeBundle) []Finding {
 		ClusterSemanticRule{},
 		HealthyClusterBaselineRule{},
 		RouteReferencesMissingClusterRule{},
+		ConfigChangeCorrelationRule{},
 	}
 
 	var findings []Finding
@@ -551,9 +582,14 @@ func (r MissingExpectedArtifactRule) Evaluate(b *AnalyzeBundle) []Finding {
 
 	var missing []string
 	for _, f := range expected {
-		if _, ok := b.Files[f]; !ok {
-			missing = append(missing, f)
+		if _, ok := b.Files[f]; ok {
+			continue
+		}
+		// --dedup replaces unchanged content with a pointer to an earlier snapshot
+		if _, ok := b.Files[f+dedupRefSuffix]; ok {
+			continue
 		}
+		missing = append(missing, f)
 	}
 
 	if len(missing) == 0 {
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+// compressedSnapshot is a snapshot archive whose log and /stats entries are
+// stored gzipped, as <name>.gz.
+const compressedSnapshot = "testdata/compressed_snapshot.tar.gz"
+
+func TestLoadAnalyzeBundleDecompressesGzippedLogs(t *testing.T) {
+	root := t.TempDir()
+	if err := extractTarGz(compressedSnapshot, root); err != nil {
+		t.Fatalf("extractTarGz: %v", err)
+	}
+	b, err := loadAnalyzeBundle(compressedSnapshot, root)
+	if err != nil {
+		t.Fatalf("loadAnalyzeBundle: %v", err)
+	}
+
+	logs, ok := b.Logs["consul-dataplane-logs.txt"]
+	if !ok {
+		t.Fatalf("gzipped log not loaded under its uncompressed name; logs: %v", keysOf(b.Logs))
+	}
+	if !strings.Contains(logs, "no healthy upstream") {
+		t.Errorf("log content = %q, want the decompressed log line", logs)
+	}
+	if findings := (NoHealthyUpstreamRule{}).Evaluate(b); len(findings) == 0 {
+		t.Error("NoHealthyUpstreamRule found nothing in the gzipped log")
+	}
+}
+
+func keysOf(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
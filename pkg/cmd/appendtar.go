@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// appendTarMu serializes appends, since every pod of a session shares one archive.
+var appendTarMu sync.Mutex
+
+// appendTarEntryDir is where a pod's files go inside the --append-tar archive:
+// <snapshot dir>/<pod archive name without .tar.gz>.
+func appendTarEntryDir(config SnapshotConfig) string {
+	name := strings.TrimSuffix(withOutputPrefix(config.OutputPrefix, podArchiveName(config)), ".tar.gz")
+	return path.Join(filepath.Base(config.OutputDir), name)
+}
+
+// appendToTar adds every file under sourceDir to the uncompressed tar at
+// tarPath, below prefix, creating the archive if needed. Entries already in the
+// archive are left in place: the end-of-archive marker is overwritten by the new
+// entries and written again after them.
+func appendToTar(tarPath, sourceDir, prefix string) (err error) {
+	appendTarMu.Lock()
+	defer appendTarMu.Unlock()
+
+	f, err := os.OpenFile(tarPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	end, err := tarEntriesEnd(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", tarPath, err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+
+	tarWriter := tar.NewWriter(f)
+	err = filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(sourceDir, file)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(fi, relPath)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(relPath))
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tarWriter, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+
+	// Drop any trailing padding left over from the previous end of the archive.
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return f.Truncate(pos)
+}
+
+// tarEntriesEnd returns the offset just past the last entry's data in f, where
+// the end-of-archive marker starts; 0 for an empty file. It reads headers only,
+// seeking over the file contents.
+func tarEntriesEnd(f *os.File) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var end int64
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		// tar.Reader reads headers straight from f, so the current offset is
+		// the start of this entry's data.
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		end = pos + (header.Size+511)/512*512
+	}
+}
+
+// gzipAppendedTar compresses the --append-tar archive into <tarPath>.gz, leaving
+// the tar itself in place so a later session can keep appending to it.
+func gzipAppendedTar(tarPath string, opts archiveOptions) (gzPath string, err error) {
+	appendTarMu.Lock()
+	defer appendTarMu.Unlock()
+
+	gzPath = tarPath + ".gz"
+	src, err := os.Open(tarPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(gzPath), "."+filepath.Base(gzPath)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := out.Name()
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	gz := newLevelSwitchingGzip(out, opts.CompressConcurrency)
+	if _, err := io.Copy(gz, src); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return "", err
+	}
+	return gzPath, os.Rename(tmpPath, gzPath)
+}
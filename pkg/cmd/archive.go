@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type archiveOptions struct {
+	// PerFileCompression stores incompressible files without recompressing them.
+	PerFileCompression bool
+	// CompressConcurrency compresses with this many goroutines when above 1.
+	CompressConcurrency int
+}
+
+// incompressibleExtensions are artifacts that are already compressed (or close to
+// random) and gain nothing from another deflate pass.
+var incompressibleExtensions = []string{".pcap", ".pcapng", ".gz", ".tgz", ".zst", ".zip"}
+
+func isIncompressible(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range incompressibleExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// levelSwitchingGzip writes a gzip stream that can change compression level between
+// tar entries. Each change closes the current gzip member and starts a new one;
+// gzip readers (including Go's and GNU gzip) transparently concatenate members.
+type levelSwitchingGzip struct {
+	out         io.Writer
+	gz          io.WriteCloser
+	level       int
+	concurrency int
+}
+
+func newLevelSwitchingGzip(out io.Writer, concurrency int) *levelSwitchingGzip {
+	w := &levelSwitchingGzip{out: out, level: gzip.DefaultCompression, concurrency: concurrency}
+	w.gz, _ = w.newMember(w.level)
+	return w
+}
+
+// newMember starts a gzip stream at level, in parallel if configured.
+func (w *levelSwitchingGzip) newMember(level int) (io.WriteCloser, error) {
+	if w.concurrency > 1 {
+		return newParallelGzip(w.out, level, w.concurrency), nil
+	}
+	return gzip.NewWriterLevel(w.out, level)
+}
+
+func (w *levelSwitchingGzip) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+func (w *levelSwitchingGzip) SetLevel(level int) error {
+	if level == w.level {
+		return nil
+	}
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	gz, err := w.newMember(level)
+	if err != nil {
+		return err
+	}
+	w.gz, w.level = gz, level
+	return nil
+}
+
+func (w *levelSwitchingGzip) Close() error {
+	return w.gz.Close()
+}
+
+// createTarGz archives sourceDir into outputFile. It writes to a uniquely named
+// temporary file next to outputFile and renames it into place once verified, so
+// concurrent writers never interleave and a partial archive is never visible.
+func createTarGz(outputFile string, sourceDir string, opts archiveOptions) (err error) {
+	tarFile, err := os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tarFile.Name()
+	defer func() {
+		tarFile.Close()
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	gzipWriter := newLevelSwitchingGzip(tarFile, opts.CompressConcurrency)
+	defer gzipWriter.Close()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer tarWriter.Close()
+
+	// expected records every entry written, for verifyTarGz.
+	expected := map[string]int64{}
+
+	err = filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(sourceDir, file)
+		if err != nil {
+			return err
+		}
+
+		if opts.PerFileCompression {
+			// flush the previous entry's padding before the gzip member may change
+			if err := tarWriter.Flush(); err != nil {
+				return err
+			}
+			level := gzip.DefaultCompression
+			if isIncompressible(relPath) {
+				level = gzip.NoCompression
+			}
+			if err := gzipWriter.SetLevel(level); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(fi, relPath)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(tarWriter, f); err != nil {
+			return err
+		}
+		expected[header.Name] = header.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Close explicitly: on a full disk the final flush is what fails.
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	if err := tarFile.Close(); err != nil {
+		return err
+	}
+	if err := verifyTarGz(tmpPath, expected); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, outputFile)
+}
+
+// verifyTarGz re-reads a written archive and checks that it decompresses and
+// holds exactly the expected entries and sizes.
+func verifyTarGz(path string, expected map[string]int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", path, err)
+	}
+	defer gzr.Close()
+
+	seen := 0
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("verify %s: %w", path, err)
+		}
+		size, ok := expected[header.Name]
+		if !ok {
+			return fmt.Errorf("verify %s: unexpected entry %s", path, header.Name)
+		}
+		n, err := io.Copy(io.Discard, tr)
+		if err != nil {
+			return fmt.Errorf("verify %s: read %s: %w", path, header.Name, err)
+		}
+		if n != size {
+			return fmt.Errorf("verify %s: %s has %d bytes, expected %d", path, header.Name, n, size)
+		}
+		seen++
+	}
+	if seen != len(expected) {
+		return fmt.Errorf("verify %s: found %d entries, expected %d", path, seen, len(expected))
+	}
+	return nil
+}
+
+// listBundleFiles prints every regular file under dir with its size, followed
+// by the uncompressed total.
+func listBundleFiles(w io.Writer, dir string) error {
+	var total int64
+	var files int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  %12d  %s\n", info.Size(), filepath.ToSlash(rel))
+		total += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "  %12d  total (%d files, uncompressed)\n", total, files)
+	return nil
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// endpointBundleFileName is written by --bundle-json next to the per-endpoint files.
+const endpointBundleFileName = "endpoints.ndjson"
+
+// EndpointRecord is one line of endpoints.ndjson. Body is the endpoint's JSON
+// output as-is, or a JSON string for text endpoints such as /clusters.
+type EndpointRecord struct {
+	Endpoint  string          `json:"endpoint"`
+	Pod       string          `json:"pod"`
+	Namespace string          `json:"namespace,omitempty"`
+	Container string          `json:"container,omitempty"`
+	Timestamp time.Time       `json:"ts"`
+	File      string          `json:"file"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// capturedEndpoint is an endpoint fetched during a capture and when.
+type capturedEndpoint struct {
+	Endpoint string
+	At       time.Time
+}
+
+// writeEndpointBundle writes one EndpointRecord per captured endpoint whose
+// file is in snapshotDir, in capture order. Endpoints written as --dedup
+// pointers or answering 404 have no file and are left out.
+func writeEndpointBundle(snapshotDir string, config SnapshotConfig, captured []capturedEndpoint) error {
+	f, err := os.Create(filepath.Join(snapshotDir, endpointBundleFileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, c := range captured {
+		file := endpointFileName(c.Endpoint)
+		data, err := os.ReadFile(filepath.Join(snapshotDir, filepath.FromSlash(file)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		body := json.RawMessage(data)
+		if !json.Valid(data) {
+			if body, err = json.Marshal(string(data)); err != nil {
+				return err
+			}
+		}
+		record := EndpointRecord{
+			Endpoint:  c.Endpoint,
+			Pod:       config.PodName,
+			Namespace: config.Namespace,
+			Container: sidecarContainer(config),
+			Timestamp: c.At,
+			File:      file,
+			Body:      body,
+		}
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -2,26 +2,44 @@ package cmd
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/markcampv/xDSnap/kube"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 func NewCaptureCommand(streams genericclioptions.IOStreams) *cobra.Command {
 	var podName, containerName, namespace string
-	var endpoints []string
+	var deployment, revision, serviceName, nodeName string
+	var endpoints, captureOrderPhases, ephemeralEnv, configDumpResourceNames, optionalEndpoints, redactRegex, requireAnnotations []string
 	var outputDir string
-	var interval, duration, repeat int
-	var enableTrace, tcpdumpEnabled bool
+	var interval, duration, repeat, tcpdumpRotate, metricsPort, adminPort, retryBudget, podRetries, compressConcurrency, expectTolerance int
+	var podRetryThreshold float64
+	var traceMaxDuration, podTimeout, eventsSince time.Duration
+	var bufferToDisk, strictExitCode, outputDirOnly, watch, captureCertsChain, drainTest, requireReady, singleArchive, endpointsFirst, resourceUsage, includeNodeInfo, failFast, streamConfigDump, dryRunTar, keepTemp, statsUsedOnly bool
+	var enableTrace, tcpdumpEnabled, pcapToText, recentLookups, initDump, bundleJSON, skipPFAfterFallback, perFileCompression, topology, collectMetrics, goroutineDump, perContainerNetns, dedup, xdsStats bool
+	var consulHTTPAddr, consulToken string
+	var mesh, containerRole, sidecarRole, stateFile, adminUDS, adminURL, mtlsProbe, adminPathPrefix, adminAuthSecret, expectFile, certsCAFile, expectedSPIFFEID string
+	var annotateFrom []string
+	var onComplete, tcpdumpMode, maxSnapshotSize, image, fallbackImage, outputPrefix, baselinePath, appendTar string
+	var appendTarGzip, waitReadyLive, redactSecretsEnabled, statsPrometheus bool
+	var waitReady, rollingWindow time.Duration
 
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -32,60 +50,344 @@ func NewCaptureCommand(streams genericclioptions.IOStreams) *cobra.Command {
 	captureCmd := &cobra.Command{
 		Use:   "capture",
 		Short: "Capture Envoy snapshots from a Consul service mesh",
-		Run: func(cmd *cobra.Command, args []string) {
-			if containerName == "consul-dataplane" {
-				log.Fatal("Error: 'consul-dataplane' cannot be used as the --container value. Please specify the application container instead.")
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mesh != MeshConsul && mesh != MeshNone {
+				log.Fatalf("Error: --mesh must be '%s' or '%s'", MeshConsul, MeshNone)
+			}
+			if mesh == MeshNone {
+				if podName == "" && deployment == "" {
+					log.Fatalf("Error: --mesh none requires --pod or --deployment")
+				}
+				if serviceName != "" || containerRole != "" || sidecarRole != "" {
+					log.Fatalf("Error: --service, --container-role and --sidecar-role need mesh metadata and cannot be used with --mesh none")
+				}
+			} else if containerName != "" {
+				if role := kube.DetectContainerRole(containerName); role != kube.RoleApp {
+					log.Fatalf("Error: '%s' is a %s container and cannot be used as the --container value. Please specify the application container instead; xDSnap locates the sidecar automatically.", containerName, role)
+				}
+			}
+
+			if nodeName != "" {
+				if podName != "" || deployment != "" || serviceName != "" {
+					log.Fatalf("Error: --node cannot be combined with --pod, --deployment or --service")
+				}
+				if mesh == MeshNone {
+					log.Fatalf("Error: --node finds Envoy by its sidecar or gateway container and cannot be used with --mesh none")
+				}
+			}
+
+			if containerName != "" && containerRole != "" {
+				log.Fatalf("Error: --container and --container-role are mutually exclusive")
+			}
+			if containerRole != "" && containerRole != kube.RoleApp {
+				log.Fatalf("Error: --container-role must be '%s'", kube.RoleApp)
+			}
+			if adminPort < 1 || adminPort > 65535 {
+				log.Fatalf("Error: --admin-port must be between 1 and 65535")
+			}
+			if sidecarRole != "" && sidecarRole != kube.RoleSidecar && sidecarRole != kube.RoleGateway {
+				log.Fatalf("Error: --sidecar-role must be '%s' or '%s'", kube.RoleSidecar, kube.RoleGateway)
 			}
 
 			// Initialize Kubernetes client config
-			config, err := rest.InClusterConfig()
+			clientset, config, err := newKubeClient()
 			if err != nil {
-				log.Printf("Could not use in-cluster config, falling back to kubeconfig: %v", err)
-				configFlags := genericclioptions.NewConfigFlags(true)
-				kubeconfig := os.Getenv("KUBECONFIG")
-				configFlags.KubeConfig = &kubeconfig
-				restConfig, err := configFlags.ToRESTConfig()
+				log.Fatalf("%v", err)
+			}
+
+			namespaces := splitNamespaces(namespace)
+			// With --node, the node's Envoy pods are found up front, in every
+			// namespace unless -n narrows it.
+			var nodePods map[string][]string
+			if nodeName != "" {
+				var scope []string
+				if namespace != "" {
+					scope = namespaces
+				}
+				namespaces, nodePods, err = discoverNodePods(clientset, nodeName, scope)
 				if err != nil {
-					log.Fatalf("Error creating Kubernetes client config: %v", err)
+					if strictExitCode && isPermissionError(err) {
+						cmd.SilenceUsage = true
+						return &ExitError{Code: ExitPermission, Err: err}
+					}
+					log.Fatalf("%v", err)
 				}
-				config = restConfig
 			}
 
-			clientset, err := kubernetes.NewForConfig(config)
+			envVars, err := parseKeyValuePairs(ephemeralEnv)
+			if err != nil {
+				log.Fatalf("Invalid --ephemeral-env: %v", err)
+			}
+			requiredAnnotations, err := parseKeyValuePairs(requireAnnotations)
 			if err != nil {
-				log.Fatalf("Error creating Kubernetes client: %v", err)
+				log.Fatalf("Invalid --require-annotation: %v", err)
 			}
 
-			if namespace == "" {
-				namespace = "default"
+			authOpts, err := adminAuthOptions(clientset, adminAuthSecret, adminPort)
+			if err != nil {
+				log.Fatalf("Invalid --admin-auth-secret: %v", err)
 			}
 
-			kubeService := kube.NewKubernetesApiService(clientset, config, namespace)
+			// outcomes aggregates every pod's result for --strict-exit-code.
+			var outcomes captureOutcomes
 
-			// Discover pods to capture
-			var podsToCapture []string
-			if podName == "" {
-				pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+			// Discover pods to capture, with one service per namespace
+			var podsToCapture []captureTarget
+			for _, ns := range namespaces {
+				if adminAuthSecret != "" && !strings.HasPrefix(adminAuthSecret, ns+"/") {
+					log.Printf("Warning: --admin-auth-secret %s is not in namespace %s; ephemeral-container fallbacks there run without admin credentials", adminAuthSecret, ns)
+				}
+				kubeService := kube.NewKubernetesApiService(clientset, config, ns, append([]kube.ServiceOption{
+					kube.WithEphemeralEnv(envVars),
+					kube.WithImage(resolveEphemeralImage(image)),
+					kube.WithFallbackImage(fallbackImage),
+				}, authOpts...)...)
+				pods, err := nodePods[ns], error(nil)
+				if nodeName == "" {
+					pods, err = discoverPods(clientset, kubeService, ns, podName, deployment, revision, serviceName)
+				}
 				if err != nil {
-					log.Fatalf("Error listing pods: %v", err)
+					if strictExitCode && isPermissionError(err) {
+						cmd.SilenceUsage = true
+						return &ExitError{Code: ExitPermission, Err: err}
+					}
+					log.Fatalf("%v", err)
 				}
-				for _, pod := range pods.Items {
-					if pod.Annotations["consul.hashicorp.com/connect-inject"] == "true" {
-						podsToCapture = append(podsToCapture, pod.Name)
+				for _, pod := range pods {
+					if len(requiredAnnotations) > 0 || requireReady {
+						reason, err := podIneligibleReason(clientset, ns, pod, requiredAnnotations, requireReady)
+						if err != nil {
+							log.Printf("Skipping pod %s/%s: %v", ns, pod, err)
+							outcomes.record(ns+"/"+pod, nil, err)
+							continue
+						}
+						if reason != "" {
+							log.Printf("Skipping pod %s/%s: %s", ns, pod, reason)
+							continue
+						}
 					}
+					podsToCapture = append(podsToCapture, captureTarget{Namespace: ns, Pod: pod, Service: kubeService})
 				}
-				if len(podsToCapture) == 0 {
-					log.Println("No pods found with the annotation consul.hashicorp.com/connect-inject=true")
-					return
+			}
+			if len(podsToCapture) == 0 {
+				if !strictExitCode {
+					return nil
 				}
-			} else {
-				podsToCapture = append(podsToCapture, podName)
+				cmd.SilenceUsage = true
+				if err := outcomes.exitError(); err != nil {
+					return err
+				}
+				return &ExitError{Code: ExitNoPods, Err: errors.New("no pods matched")}
+			}
+			// Same-named pods in different namespaces would share an archive name.
+			podNameCount := map[string]int{}
+			for _, t := range podsToCapture {
+				podNameCount[t.Pod]++
+			}
+
+			kubeServerVersion, err := podsToCapture[0].Service.ServerVersion()
+			if err != nil {
+				log.Printf("Could not determine Kubernetes server version: %v", err)
+			}
+			setFlags := map[string]string{}
+			cmd.Flags().Visit(func(f *pflag.Flag) {
+				setFlags[f.Name] = f.Value.String()
+			})
+			if _, ok := setFlags["ephemeral-env"]; ok {
+				// Values may carry proxy credentials.
+				setFlags["ephemeral-env"] = "<redacted>"
+			}
+			if _, ok := setFlags["consul-token"]; ok {
+				setFlags["consul-token"] = "<redacted>"
+			}
+			if _, ok := setFlags["admin-url"]; ok {
+				setFlags["admin-url"] = redactURLUserinfo(adminURL)
+			}
+			if _, ok := setFlags["redact-regex"]; ok {
+				// The patterns themselves may spell out the values being hidden.
+				setFlags["redact-regex"] = fmt.Sprintf("<%d pattern(s)>", len(redactRegex))
 			}
 
 			// Validation
+			if adminUDS != "" && !strings.HasPrefix(adminUDS, "/") && !strings.HasPrefix(adminUDS, "@") {
+				log.Fatalf("--admin-uds must be an absolute socket path or an abstract name starting with '@'")
+			}
+			if err := validateAdminPathPrefix(adminPathPrefix); err != nil {
+				log.Fatalf("Invalid --admin-path-prefix: %v", err)
+			}
+			if adminURL != "" {
+				if err := validateAdminURL(adminURL); err != nil {
+					log.Fatalf("Invalid --admin-url: %v", err)
+				}
+				if adminUDS != "" {
+					log.Fatalf("--admin-url cannot be combined with --admin-uds")
+				}
+				if len(podsToCapture) > 1 {
+					log.Fatalf("--admin-url reaches a single Envoy, but %d pods were selected; use --pod", len(podsToCapture))
+				}
+			}
+			if mtlsProbe != "" {
+				if err := validateMTLSProbeTarget(mtlsProbe); err != nil {
+					log.Fatalf("Invalid --mtls-probe: %v", err)
+				}
+			}
+			redactPatterns, err := compileRedactPatterns(redactRegex)
+			if err != nil {
+				log.Fatalf("Invalid --redact-regex: %v", err)
+			}
+			if compressConcurrency < 0 {
+				log.Fatalf("--compress-concurrency must not be negative")
+			}
+			if streamConfigDump && redactSecretsEnabled {
+				log.Printf("Warning: --stream-config-dump is ignored while --redact is on; pass --redact=false to stream /config_dump unredacted")
+			}
+			if waitReady < 0 {
+				log.Fatalf("--wait-ready must not be negative")
+			}
+			if waitReadyLive && waitReady == 0 {
+				log.Fatalf("--wait-ready-live requires --wait-ready")
+			}
+			if retryBudget < 0 {
+				log.Fatalf("--retry-budget must not be negative")
+			}
+			if podRetries < 0 {
+				log.Fatalf("--pod-retries must not be negative")
+			}
+			if singleArchive && maxSnapshotSize != "" {
+				log.Fatalf("--single-archive cannot be combined with --max-snapshot-size")
+			}
+			if watch && stateFile != "" {
+				log.Fatalf("--watch cannot be combined with --state-file")
+			}
+			if outputDirOnly && (singleArchive || dryRunTar || maxSnapshotSize != "") {
+				log.Fatalf("--output-dir-only cannot be combined with --single-archive, --dry-run-tar or --max-snapshot-size")
+			}
+			if appendTar != "" {
+				if singleArchive || outputDirOnly || dryRunTar || maxSnapshotSize != "" {
+					log.Fatalf("--append-tar cannot be combined with --single-archive, --output-dir-only, --dry-run-tar or --max-snapshot-size")
+				}
+				if !strings.HasSuffix(appendTar, ".tar") {
+					log.Fatalf("--append-tar must name an uncompressed .tar archive, got %q", appendTar)
+				}
+			} else if appendTarGzip {
+				log.Fatalf("--append-tar-gzip requires --append-tar")
+			}
+			if expectFile != "" && dedup {
+				log.Fatalf("--expect cannot be combined with --dedup")
+			}
+			if expectTolerance < 0 {
+				log.Fatalf("--expect-tolerance must not be negative")
+			}
+			if singleArchive && stateFile != "" {
+				log.Fatalf("--single-archive cannot be combined with --state-file")
+			}
+			if podRetryThreshold < 0 || podRetryThreshold > 1 {
+				log.Fatalf("--pod-retry-threshold must be between 0 and 1")
+			}
 			if interval < 5 {
 				log.Fatalf("Interval must be at least 5 seconds")
 			}
+			if tcpdumpMode != TcpdumpModeLogs && tcpdumpMode != TcpdumpModeFile {
+				log.Fatalf("--tcpdump-mode must be %q or %q", TcpdumpModeLogs, TcpdumpModeFile)
+			}
+			if pcapToText && !tcpdumpEnabled {
+				log.Fatalf("--pcap-to-text requires --tcpdump")
+			}
+			if tcpdumpRotate < 0 {
+				log.Fatalf("--tcpdump-rotate-seconds must not be negative")
+			}
+			if rollingWindow < 0 {
+				log.Fatalf("--rolling-window must not be negative")
+			}
+			if rollingWindow > 0 && tcpdumpEnabled && tcpdumpRotate == 0 {
+				log.Fatalf("--rolling-window with --tcpdump requires --tcpdump-rotate-seconds, so old pcap slices can be discarded")
+			}
+			if metricsPort < 0 || metricsPort > 65535 {
+				log.Fatalf("--metrics-port must be between 1 and 65535")
+			}
+
+			if consulHTTPAddr != "" && mesh == MeshNone {
+				log.Fatalf("--consul-http-addr cannot be used with --mesh none")
+			}
+			// Consul's own environment variables work as defaults.
+			if consulHTTPAddr == "" && mesh != MeshNone {
+				consulHTTPAddr = os.Getenv("CONSUL_HTTP_ADDR")
+			}
+			if consulToken == "" {
+				consulToken = os.Getenv("CONSUL_HTTP_TOKEN")
+			}
+			var consulClient *ConsulConfigClient
+			if consulHTTPAddr != "" {
+				if !strings.Contains(consulHTTPAddr, "://") {
+					consulHTTPAddr = "http://" + consulHTTPAddr
+				}
+				consulClient = &ConsulConfigClient{Addr: consulHTTPAddr, Token: consulToken}
+			}
+
+			var phaseOrder []string
+			if len(captureOrderPhases) > 0 {
+				if endpointsFirst {
+					log.Fatalf("--capture-order cannot be combined with --endpoints-first")
+				}
+				phaseOrder, err = parseCaptureOrder(captureOrderPhases)
+				if err != nil {
+					log.Fatalf("Invalid --capture-order: %v", err)
+				}
+			}
+
+			var maxSnapshotBytes int64
+			if maxSnapshotSize != "" {
+				q, err := resource.ParseQuantity(maxSnapshotSize)
+				if err != nil || q.Value() <= 0 {
+					log.Fatalf("Invalid --max-snapshot-size %q: expected a positive size such as 25Mi or 25M", maxSnapshotSize)
+				}
+				maxSnapshotBytes = q.Value()
+			}
+			if strings.ContainsAny(outputPrefix, `/\`) {
+				log.Fatalf("--output-prefix must not contain path separators")
+			}
+			if onComplete != "" {
+				if _, err := parseOnCompleteHook(onComplete); err != nil {
+					log.Fatalf("Invalid --on-complete template: %v", err)
+				}
+			}
+
+			if len(endpoints) == 0 {
+				endpoints = resolveDefaultEndpoints()
+			}
+			if recentLookups {
+				endpoints = append(endpoints, RecentLookupsEndpoint)
+			}
+			if initDump && !containsString(optionalEndpoints, InitDumpEndpoint) {
+				// Older Envoys answer 404, which is not a failure.
+				optionalEndpoints = append(optionalEndpoints, InitDumpEndpoint)
+			}
+			for _, endpoint := range optionalEndpoints {
+				if !containsString(endpoints, endpoint) {
+					endpoints = append(endpoints, endpoint)
+				}
+			}
+			if xdsStats {
+				endpoints = append(endpoints, XDSStatsEndpoint)
+			}
+			if statsPrometheus && !containsString(endpoints, StatsPrometheusEndpoint) {
+				endpoints = append(endpoints, StatsPrometheusEndpoint)
+			}
+			if len(configDumpResourceNames) > 0 {
+				resourceEndpoints, err := configDumpResourceEndpoints(configDumpResourceNames)
+				if err != nil {
+					log.Fatalf("Invalid --config-dump-resources: %v", err)
+				}
+				endpoints = append(endpoints, resourceEndpoints...)
+			}
+
+			// Probe once per namespace so one without ephemeral containers degrades
+			// with a single message instead of failing every log-level, tcpdump and
+			// exec step.
+			ephemeralDisabled := probeEphemeralContainers(podsToCapture)
+			if len(ephemeralDisabled) > 0 && tcpdumpEnabled {
+				log.Printf("Warning: --tcpdump requires ephemeral containers and will be skipped where they are unavailable.")
+			}
 
 			if repeat > 0 {
 				log.Printf("Starting snapshot capture with sleep=%ds repeat=%d trace=%v tcpdump=%v outputDir=%s",
@@ -95,99 +397,933 @@ func NewCaptureCommand(streams genericclioptions.IOStreams) *cobra.Command {
 					interval, duration, enableTrace, tcpdumpEnabled, outputDir)
 			}
 
+			var baseline *StatsBaseline
+			if baselinePath != "" {
+				baseline, err = loadStatsBaseline(baselinePath)
+				if err != nil {
+					log.Fatalf("Failed to load --baseline %s: %v", baselinePath, err)
+				}
+				if !containsString(endpoints, "/stats") {
+					endpoints = append(endpoints, "/stats")
+				}
+			}
+
+			var certChainCA []*x509.Certificate
+			if certsCAFile != "" {
+				if certChainCA, err = loadCAFile(certsCAFile); err != nil {
+					log.Fatalf("Failed to load --certs-ca-file %s: %v", certsCAFile, err)
+				}
+			}
+			if captureCertsChain && !containsString(endpoints, "/config_dump") {
+				endpoints = append(endpoints, "/config_dump")
+			}
+
+			var expected any
+			if expectFile != "" {
+				if expected, err = loadExpectedConfig(expectFile); err != nil {
+					log.Fatalf("Failed to load --expect %s: %v", expectFile, err)
+				}
+				if !containsString(endpoints, "/config_dump") {
+					endpoints = append(endpoints, "/config_dump")
+				}
+			}
+			// session compares each pod's health across iterations.
+			session := newSessionRecorder()
+			// driftedPods counts captures that failed the --expect comparison.
+			driftedPods := 0
+
+			var endpointDedup *EndpointDedup
+			if dedup {
+				endpointDedup = NewEndpointDedup()
+			}
+
 			captures := 0
 			var startTime time.Time
 
-			// Capture loop
-			for {
-				if repeat > 0 && captures >= repeat {
-					log.Println("Repeat count reached, stopping capture")
-					break
+			var state *CaptureState
+			saveState := func() {}
+			if stateFile != "" {
+				state, err = loadCaptureState(stateFile)
+				if err != nil {
+					log.Fatalf("Failed to load --state-file: %v", err)
+				}
+				captures = state.Iterations
+				startTime = state.StartedAt
+				if captures > 0 || state.SnapshotDir != "" {
+					log.Printf("Resuming from %s: %d iteration(s) completed", stateFile, captures)
 				}
+				saveState = func() {
+					if err := state.save(stateFile); err != nil {
+						log.Printf("Failed to update --state-file: %v", err)
+					}
+				}
+			}
 
-				if repeat == 0 && duration > 0 && !startTime.IsZero() && time.Since(startTime) >= time.Duration(duration)*time.Second {
-					log.Println("Duration ended, stopping capture")
-					break
+			// SIGINT or SIGTERM cancels ctx: no new capture work is started, the
+			// pod being captured is bundled as far as it got, in-flight ephemeral
+			// containers are stopped and raised Envoy log levels are reset. A second
+			// signal exits immediately.
+			ctx, stopSignals := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stopSignals()
+			services := map[kube.KubernetesApiService]bool{}
+			for _, target := range podsToCapture {
+				services[target.Service] = true
+			}
+			cleanupEphemeral := func() {
+				for service := range services {
+					if err := service.CleanupEphemeral(); err != nil {
+						log.Printf("Failed to clean up ephemeral containers: %v", err)
+					}
 				}
+			}
+			sessionDone := make(chan struct{})
+			defer close(sessionDone)
+			cleanupDone := make(chan struct{})
+			go func() {
+				defer close(cleanupDone)
+				select {
+				case <-ctx.Done():
+				case <-sessionDone:
+					return
+				}
+				stopSignals()
+				log.Println("Interrupted; stopping in-flight ephemeral containers (interrupt again to exit immediately)")
+				cleanupEphemeral()
+			}()
+			// pendingResets holds, per pod, the log-level reset skipped between
+			// --repeat iterations. Whatever is left when the session ends, however
+			// it ends, is run by resetPending.
+			pendingResets := map[string]func() error{}
+			resetPending := func() {
+				for key, reset := range pendingResets {
+					log.Printf("Resetting Envoy log level back to 'info' on pod: %s", key)
+					if err := reset(); err != nil {
+						log.Printf("Failed to reset log level on pod %s: %v", key, err)
+					}
+					delete(pendingResets, key)
+				}
+			}
+			// cleanupSession stops in-flight ephemeral containers and resets raised
+			// log levels, for a session cut short.
+			cleanupSession := func() {
+				cleanupEphemeral()
+				resetPending()
+				log.Println("Cleanup complete")
+			}
+			// finishInterrupted waits for the cleanup started by the signal, then
+			// catches ephemeral containers started meanwhile.
+			finishInterrupted := func() {
+				<-cleanupDone
+				cleanupSession()
+			}
+			// failFastErr is the pod failure that stopped the session under
+			// --fail-fast.
+			var failFastErr error
+			// failFastExit ends a session stopped by --fail-fast.
+			failFastExit := func() error {
+				cleanupSession()
+				cmd.SilenceUsage = true
+				code := 1
+				if strictExitCode {
+					code = ExitCode(outcomes.exitError())
+				}
+				return &ExitError{Code: code, Err: failFastErr}
+			}
 
-				timestamp := time.Now().Format("20060102_150405")
-				snapshotDir := fmt.Sprintf("%s/snapshot_%s", outputDir, timestamp)
+			// captureRound snapshots every target pod into snapshotDir.
+			captureRound := func(snapshotDir string, targets []captureTarget, finalReset bool) {
 				if err := os.MkdirAll(snapshotDir, 0755); err != nil {
 					log.Printf("Failed to create snapshot directory: %v", err)
-					continue
+					return
 				}
 
-				for _, pod := range podsToCapture {
+				var summaries []PodSummary
+				defer func() {
+					if err := printCaptureSummary(streams.Out, summaries); err != nil {
+						log.Printf("Failed to print capture summary: %v", err)
+					}
+				}()
+
+				// With --single-archive every pod is staged under a shared root and
+				// bundled into one tarball once the round is done.
+				var stage *singleArchiveStage
+				if singleArchive {
+					var err error
+					stage, err = newSingleArchiveStage(CombinedManifest{
+						SchemaVersion: ManifestSchemaVersion,
+						Tool:          ManifestTool{Name: "xdsnap", Version: toolVersion(), KubeServerVersion: kubeServerVersion},
+						IncidentID:    outputPrefix,
+						CapturedAt:    time.Now(),
+					})
+					if err != nil {
+						log.Printf("Failed to create staging directory: %v", err)
+						return
+					}
+					defer func() {
+						if keepTemp || failFastErr != nil {
+							log.Printf("Kept staging directory: %s", stage.root)
+							return
+						}
+						os.RemoveAll(stage.root)
+					}()
+				}
+
+				for _, target := range targets {
+					if ctx.Err() != nil {
+						break
+					}
+					pod, kubeService := target.Pod, target.Service
+					if state != nil && state.podCompleted(snapshotDir, target.key()) {
+						log.Printf("Pod %s already captured in %s, skipping", target.key(), snapshotDir)
+						continue
+					}
+
+					var deadline time.Time
+					if repeat == 0 && duration > 0 && !startTime.IsZero() {
+						deadline = startTime.Add(time.Duration(duration) * time.Second)
+					}
+					if !deadline.IsZero() && time.Now().After(deadline) {
+						log.Printf("Overall deadline passed; skipping pod %s", target.key())
+						summaries = append(summaries, PodSummary{Target: target.key(), Outcome: OutcomeSkippedDeadline})
+						outcomes.record(target.key(), &CaptureResult{Outcome: OutcomeSkippedDeadline}, nil)
+						continue
+					}
+
 					containers, err := kubeService.ListContainers(pod)
 					if err != nil {
 						log.Printf("Failed to list containers for pod %s: %v", pod, err)
+						outcomes.record(target.key(), nil, err)
 						continue
 					}
 
 					// Automatically detect sidecar / gateway container
-					sidecar, err := kubeService.PickSidecarContainer(pod, containers)
-					if err != nil {
+					var sidecar string
+					if mesh == MeshNone {
+						if sidecar, err = pickBareEnvoyContainer(containers, containerName); err != nil {
+							log.Printf("Skipping pod %s: %v", pod, err)
+							continue
+						}
+					} else if sidecarRole != "" {
+						var ok bool
+						if sidecar, ok = kube.FindContainerByRole(containers, sidecarRole); !ok {
+							log.Printf("No %s container found in pod %s, skipping", sidecarRole, pod)
+							continue
+						}
+					} else if sidecar, err = kubeService.PickSidecarContainer(pod, containers); err != nil {
 						log.Printf("%v", err)
 						continue
 					}
 
-					finalReset := repeat == 0 || captures == repeat-1
+					appContainer := containerName
+					if mesh == MeshNone {
+						appContainer = ""
+					}
+					if containerRole != "" {
+						var ok bool
+						if appContainer, ok = kube.FindContainerByRole(containers, containerRole); !ok {
+							log.Printf("No %s container found in pod %s, skipping", containerRole, pod)
+							continue
+						}
+					}
 
 					log.Printf("Calling CaptureSnapshot -> pod: %s | container: %s | enableTrace: %v | tcpdump: %v | extraLogs: [%s] | finalReset: %v",
-						pod, containerName, enableTrace, tcpdumpEnabled, sidecar, finalReset)
+						pod, appContainer, enableTrace, tcpdumpEnabled, sidecar, finalReset)
 
 					snapshotConfig := SnapshotConfig{
-						PodName: pod,
+						PodName:   pod,
+						Namespace: target.Namespace,
 						ContainerName: func() string {
-							if containerName != "" {
-								return containerName
+							if appContainer != "" {
+								return appContainer
 							}
 							return sidecar
 						}(),
-						Endpoints:         endpoints,
-						OutputDir:         snapshotDir,
-						ExtraLogs:         []string{sidecar},
-						EnableTrace:       enableTrace,
-						TcpdumpEnabled:    tcpdumpEnabled,
-						Duration:          time.Duration(duration) * time.Second,
-						SkipLogLevelReset: !finalReset,
+						Endpoints:                    endpoints,
+						EndpointsFirst:               endpointsFirst,
+						CaptureOrder:                 phaseOrder,
+						BundleJSON:                   bundleJSON,
+						BufferLogsToDisk:             bufferToDisk,
+						OptionalEndpoints:            optionalEndpoints,
+						ResourceUsage:                resourceUsage,
+						IncludeNodeInfo:              includeNodeInfo,
+						EventsSince:                  eventsSince,
+						FailFast:                     failFast,
+						AdminUDS:                     adminUDS,
+						AdminPort:                    adminPort,
+						AdminPathPrefix:              adminPathPrefix,
+						WaitReady:                    waitReady,
+						WaitReadyLive:                waitReadyLive,
+						RetryBudget:                  retryBudget,
+						Consul:                       consulClient,
+						AdminURL:                     adminURL,
+						SkipPortForwardAfterFallback: skipPFAfterFallback,
+						MTLSProbe:                    mtlsProbe,
+						PerContainerNetns:            perContainerNetns,
+						StreamConfigDump:             streamConfigDump,
+						Redact:                       redactPatterns,
+						RedactSecrets:                redactSecretsEnabled,
+						DrainTest:                    drainTest,
+						CertChain:                    captureCertsChain,
+						CertChainCA:                  certChainCA,
+						CertChainCAFile:              certsCAFile,
+						ExpectedSPIFFEID:             expectedSPIFFEID,
+						Expected:                     expected,
+						ExpectedFile:                 expectFile,
+						ExpectedTolerance:            expectTolerance,
+						StatsUsedOnly:                statsUsedOnly,
+						CompressConcurrency:          compressConcurrency,
+						DryRunTar:                    dryRunTar,
+						KeepTemp:                     keepTemp,
+						AppendTar:                    appendTar,
+						KubeServerVersion:            kubeServerVersion,
+						Flags:                        setFlags,
+						OutputDir:                    snapshotDir,
+						ExtraLogs:                    extraLogs(mesh, sidecar),
+						Mesh:                         mesh,
+						NamespacedArchive:            podNameCount[pod] > 1,
+						DirectoryOnly:                outputDirOnly,
+						EnableTrace:                  enableTrace,
+						TcpdumpEnabled:               tcpdumpEnabled,
+						PcapSummary:                  pcapToText,
+						TcpdumpRotate:                time.Duration(tcpdumpRotate) * time.Second,
+						RollingWindow:                rollingWindow,
+						TcpdumpMode:                  tcpdumpMode,
+						PerFileCompression:           perFileCompression,
+						EphemeralDisabled:            ephemeralDisabled[kubeService],
+						Topology:                     topology,
+						MaxSnapshotSize:              maxSnapshotBytes,
+						CollectMetrics:               collectMetrics || metricsPort > 0,
+						MetricsPort:                  metricsPort,
+						GoroutineDump:                goroutineDump,
+						Dedup:                        endpointDedup,
+						TraceMaxDuration:             traceMaxDuration,
+						Duration:                     time.Duration(duration) * time.Second,
+						SkipLogLevelReset:            !finalReset,
+						OnComplete:                   onComplete,
+						OutputPrefix:                 outputPrefix,
+					}
+					if stage != nil {
+						snapshotConfig.StageDir = stage.podDir(target)
+					}
+					if len(annotateFrom) > 0 {
+						if snapshotConfig.Metadata, err = annotationMetadata(clientset, target.Namespace, pod, annotateFrom); err != nil {
+							log.Printf("Failed to read annotations of pod %s: %v", target.key(), err)
+						}
+					}
+					if baseline != nil && (baseline.PodName == "" || baseline.PodName == pod) {
+						snapshotConfig.Baseline = baseline
 					}
 
-					if repeat == 0 && duration > 0 && startTime.IsZero() {
+					if repeat == 0 && duration > 0 && startTime.IsZero() && !watch {
 						startTime = time.Now()
+						if state != nil {
+							state.StartedAt = startTime
+							saveState()
+						}
 					}
 
-					if err := CaptureSnapshot(kubeService, snapshotConfig); err != nil {
+					podStarted := time.Now()
+					result, err := CaptureSnapshot(ctx, kubeService, snapshotConfig)
+					for attempt := 1; attempt <= podRetries && err == nil && !result.Interrupted && result.EndpointSuccessRatio() < podRetryThreshold; attempt++ {
+						log.Printf("Only %d of %d endpoints captured for pod %s; retrying the whole capture (%d/%d)",
+							result.EndpointsCaptured, result.EndpointsCaptured+result.EndpointsFailed, pod, attempt, podRetries)
+						result, err = CaptureSnapshot(ctx, kubeService, snapshotConfig)
+					}
+					if snapshotConfig.SkipLogLevelReset && !snapshotConfig.EphemeralDisabled && (result == nil || !result.Interrupted) {
+						pendingResets[target.key()] = func() error {
+							return newSnapshotAdminClient(kubeService, snapshotConfig).SetLogLevel("info")
+						}
+					} else {
+						delete(pendingResets, target.key())
+					}
+					elapsed := time.Since(podStarted)
+					if err != nil {
 						log.Printf("Error capturing snapshot for pod %s: %v", pod, err)
+						summaries = append(summaries, PodSummary{Target: target.key(), Outcome: OutcomeFailed, Elapsed: elapsed, Detail: err.Error()})
+						outcomes.record(target.key(), nil, err)
+						if failFast {
+							failFastErr = fmt.Errorf("capture of pod %s failed with --fail-fast: %w", target.key(), err)
+							break
+						}
+						continue
+					}
+					if result.ConfigDriftExceeded {
+						driftedPods++
+					}
+					result.Outcome = captureOutcome(elapsed, podTimeout, deadline, time.Now())
+					outcomes.record(target.key(), result, nil)
+					session.add(target.key(), result)
+					if result.Outcome == OutcomePodTimeout {
+						log.Printf("Capture of pod %s took %s, longer than --pod-timeout %s", target.key(), elapsed.Round(time.Second), podTimeout)
+					}
+					summaries = append(summaries, PodSummary{
+						Target:    target.key(),
+						Outcome:   result.Outcome,
+						Elapsed:   elapsed,
+						Endpoints: fmt.Sprintf("%d/%d", result.EndpointsCaptured, result.EndpointsCaptured+result.EndpointsFailed),
+					})
+					if stage != nil {
+						stage.add(target, result)
+					} else if result.TarPath != "" || result.SnapshotDir != "" {
+						if err := appendSnapshotIndex(outputDir, result); err != nil {
+							log.Printf("Failed to update %s: %v", indexFileName, err)
+						}
+					}
+					if state != nil && state.SnapshotDir == snapshotDir {
+						state.CompletedPods = append(state.CompletedPods, target.key())
+						saveState()
+					}
+				}
+
+				if stage == nil || failFastErr != nil {
+					return
+				}
+				tarPath := singleArchivePath(snapshotDir, outputPrefix)
+				archiveOpts := archiveOptions{PerFileCompression: perFileCompression, CompressConcurrency: compressConcurrency}
+				staged, err := stage.bundle(tarPath, archiveOpts, dryRunTar)
+				if err != nil {
+					log.Printf("Failed to bundle %s: %v", tarPath, err)
+					return
+				}
+				if len(staged) == 0 || dryRunTar {
+					return
+				}
+				// Every pod is indexed against the shared archive.
+				for _, result := range staged {
+					result.TarPath = tarPath
+					if err := appendSnapshotIndex(outputDir, result); err != nil {
+						log.Printf("Failed to update %s: %v", indexFileName, err)
+					}
+				}
+				if onComplete != "" {
+					roundResult := &CaptureResult{OutputDir: snapshotDir, TarPath: tarPath, StartedAt: stage.manifest.CapturedAt, CompletedAt: time.Now()}
+					if err := runOnCompleteHook(onComplete, roundResult); err != nil {
+						log.Printf("On-complete hook failed for %s: %v", tarPath, err)
+					}
+				}
+			}
+
+			if watch {
+				log.Printf("Watching %d pod(s) for container restarts", len(podsToCapture))
+				restarts := make(chan podRestart)
+				watchCtx, stopWatch := context.WithCancel(ctx)
+				defer stopWatch()
+				go watchPodRestarts(watchCtx, clientset, podsToCapture, restarts)
+				for {
+					var r podRestart
+					var ok bool
+					select {
+					case <-ctx.Done():
+					case r, ok = <-restarts:
+					}
+					if ctx.Err() != nil {
+						finishInterrupted()
+						return nil
+					}
+					if !ok {
+						return nil
+					}
+					log.Printf("Container %s in pod %s restarted (restartCount=%d), capturing a snapshot", r.Container, r.Target.key(), r.RestartCount)
+					timestamp := time.Now().Format("20060102_150405")
+					name := fmt.Sprintf("snapshot_%s_%s_%s_restart%d", timestamp, r.Target.Pod, r.Container, r.RestartCount)
+					captureRound(filepath.Join(outputDir, withOutputPrefix(outputPrefix, name)), []captureTarget{r.Target}, true)
+					if failFastErr != nil {
+						return failFastExit()
+					}
+				}
+			}
+
+			// SIGUSR1 triggers an immediate out-of-band snapshot while waiting
+			// between scheduled captures; it does not count toward --repeat.
+			onDemand := make(chan os.Signal, 1)
+			notifyOnDemandSnapshot(onDemand)
+			defer stopOnDemandSnapshot(onDemand)
+
+			sleepBetweenCaptures := func(d time.Duration) {
+				timer := time.NewTimer(d)
+				defer timer.Stop()
+				for {
+					select {
+					case <-timer.C:
+						return
+					case <-ctx.Done():
+						return
+					case <-onDemand:
+						log.Println("Received SIGUSR1, capturing an on-demand snapshot")
+						timestamp := time.Now().Format("20060102_150405")
+						// The level is reset right away: the session may end before
+						// another scheduled round would reset it.
+						captureRound(filepath.Join(outputDir, withOutputPrefix(outputPrefix, fmt.Sprintf("snapshot_%s_ondemand", timestamp))), podsToCapture, true)
+						if failFastErr != nil {
+							return
+						}
 					}
 				}
+			}
+
+			// Capture loop
+			for {
+				if ctx.Err() != nil {
+					log.Println("Interrupted, stopping capture")
+					break
+				}
+				if repeat > 0 && captures >= repeat {
+					log.Println("Repeat count reached, stopping capture")
+					break
+				}
+
+				if repeat == 0 && duration > 0 && !startTime.IsZero() && time.Since(startTime) >= time.Duration(duration)*time.Second {
+					log.Println("Duration ended, stopping capture")
+					break
+				}
+
+				timestamp := time.Now().Format("20060102_150405")
+				snapshotDir := filepath.Join(outputDir, withOutputPrefix(outputPrefix, fmt.Sprintf("snapshot_%s", timestamp)))
+				if state != nil {
+					// Finish an interrupted round in its original directory.
+					if state.SnapshotDir != "" {
+						snapshotDir = state.SnapshotDir
+					} else {
+						state.SnapshotDir = snapshotDir
+						saveState()
+					}
+				}
+				captureRound(snapshotDir, podsToCapture, repeat == 0 || captures == repeat-1)
+				if failFastErr != nil {
+					break
+				}
+				if ctx.Err() != nil {
+					// Leave --state-file pointing at the unfinished round.
+					continue
+				}
 
 				captures++
+				if state != nil {
+					state.Iterations = captures
+					state.SnapshotDir = ""
+					state.CompletedPods = nil
+					saveState()
+				}
 
 				if repeat > 0 && captures < repeat {
 					log.Printf("Sleeping %ds before next snapshot (repeat mode)", interval)
-					time.Sleep(time.Duration(interval) * time.Second)
+					sleepBetweenCaptures(time.Duration(interval) * time.Second)
 				} else if repeat == 0 {
-					time.Sleep(time.Duration(interval) * time.Second)
+					sleepBetweenCaptures(time.Duration(interval) * time.Second)
+				}
+				if failFastErr != nil {
+					break
+				}
+			}
+
+			if failFastErr != nil {
+				return failFastExit()
+			}
+			if ctx.Err() != nil {
+				finishInterrupted()
+			} else {
+				resetPending()
+			}
+
+			if wrote, err := session.write(outputDir); err != nil {
+				log.Printf("Failed to write %s: %v", sessionTrendsFileName, err)
+			} else if wrote {
+				log.Printf("Session trends written to %s.json and %s.txt", filepath.Join(outputDir, sessionTrendsFileName), filepath.Join(outputDir, sessionTrendsFileName))
+			}
+			if appendTarGzip {
+				archiveOpts := archiveOptions{CompressConcurrency: compressConcurrency}
+				if gzPath, err := gzipAppendedTar(appendTar, archiveOpts); err != nil {
+					log.Printf("Failed to compress %s: %v", appendTar, err)
+				} else {
+					log.Printf("Compressed %s into %s", appendTar, gzPath)
+				}
+			}
+			if driftedPods > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d capture(s) drifted from --expect %s beyond the tolerance of %d field(s)", driftedPods, expectFile, expectTolerance)
+			}
+			if strictExitCode {
+				if err := outcomes.exitError(); err != nil {
+					cmd.SilenceUsage = true
+					return err
 				}
 			}
+			return nil
 		},
 	}
 
 	// CLI flags
 	captureCmd.Flags().StringVar(&podName, "pod", "", "Pod name (optional; defaults to all pods with connect-inject=true)")
-	captureCmd.Flags().StringVar(&containerName, "container", "", "Name of the application container (optional)")
+	captureCmd.Flags().StringVar(&deployment, "deployment", "", "Capture the pods of this Deployment (optional)")
+	captureCmd.Flags().StringVar(&revision, "revision", kube.RevisionAll, "With --deployment, select pods from the 'new' ReplicaSet, the 'old' ones, or 'all'")
+	captureCmd.Flags().StringVar(&nodeName, "node", "", "Capture every pod with a sidecar or gateway container scheduled on this node (in every namespace unless -n is set)")
+	captureCmd.Flags().StringVar(&serviceName, "service", "", "Capture the connect-injected pods of this Consul service (matches the consul.hashicorp.com/connect-service annotation)")
+	captureCmd.Flags().StringArrayVar(&requireAnnotations, "require-annotation", nil, "Only capture resolved pods carrying this KEY=VALUE annotation, e.g. a debug opt-in (repeatable; all must match)")
+	captureCmd.Flags().BoolVar(&requireReady, "require-ready", false, "Only capture resolved pods whose Ready condition is true, skipping pods mid-startup")
+	captureCmd.Flags().DurationVar(&waitReady, "wait-ready", 0, "Poll Envoy's /ready for up to this long before capturing, so snapshots reflect a settled proxy (e.g. 60s); unready proxies are still captured")
+	captureCmd.Flags().BoolVar(&waitReadyLive, "wait-ready-live", false, "With --wait-ready, also wait for the /server_info state to be LIVE")
+	captureCmd.Flags().StringVar(&containerName, "container", "", "Name of the application container (optional); with --mesh none, the Envoy container")
+	captureCmd.Flags().StringVar(&mesh, "mesh", MeshConsul, "Service mesh of the target pods: 'consul', or 'none' for standalone Envoy pods (no mesh discovery or sidecar detection)")
+	captureCmd.Flags().StringVar(&containerRole, "container-role", "", "Select the application container by detected role instead of by name ('app')")
+	captureCmd.Flags().StringVar(&sidecarRole, "sidecar-role", "", "Select the Envoy container by detected role ('sidecar' or 'gateway') instead of auto-detection; pods without one are skipped")
 	captureCmd.Flags().StringSliceVar(&endpoints, "endpoints", []string{}, "Envoy admin API endpoints to capture (e.g. /stats,/config_dump)")
+	captureCmd.Flags().DurationVar(&podTimeout, "pod-timeout", 0, "Flag pods whose capture takes longer than this as pod_timeout in the summary and index.json (e.g. 3m)")
+	captureCmd.Flags().IntVar(&podRetries, "pod-retries", 0, "Re-run a pod's whole capture up to N times when too few endpoints succeed, overwriting the partial archive")
+	captureCmd.Flags().Float64Var(&podRetryThreshold, "pod-retry-threshold", 0.5, "With --pod-retries, the minimum share of endpoints (0-1) that must succeed to keep a capture")
+	captureCmd.Flags().BoolVar(&strictExitCode, "strict-exit-code", false, "Exit 2 on partial data (failed pods or endpoints), 3 on RBAC denials, 4 when no pods matched and 5 on timeouts, instead of 0")
+	captureCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Abort with a non-zero exit on the first endpoint or log stream failure instead of continuing; the partial capture is kept for inspection")
+	captureCmd.Flags().StringSliceVar(&optionalEndpoints, "optional-endpoints", nil, "Endpoints to capture where a 404 means 'not available on this Envoy version' rather than a failure (e.g. /stats/recentlookups)")
+	captureCmd.Flags().StringSliceVar(&captureOrderPhases, "capture-order", nil, "Sequence of capture phases: config, stats, endpoints, logs, tcpdump (e.g. stats,config,logs); omitted phases run afterwards")
+	captureCmd.Flags().BoolVar(&endpointsFirst, "endpoints-first", false, "Fetch every admin endpoint before starting logs and tcpdump (by default only /config_dump is fetched first)")
+	captureCmd.Flags().StringVar(&adminPathPrefix, "admin-path-prefix", "", "Path prefix of a reverse-proxied admin API (e.g. /envoy-admin), prepended to every endpoint and the /logging call")
+	captureCmd.Flags().StringVar(&adminAuthSecret, "admin-auth-secret", "", "Secret (namespace/name) with username and password keys, sent as Basic Auth on admin requests; ephemeral containers reference it only in its own namespace")
+	captureCmd.Flags().IntVar(&retryBudget, "retry-budget", 0, "Total endpoint retries (port-forward retries and exec fallbacks) allowed per pod capture; once spent, remaining endpoints are tried once. 0 means unlimited")
+	captureCmd.Flags().BoolVar(&skipPFAfterFallback, "skip-endpoints-on-exec-fallback", true, "Once port-forward has failed and the ephemeral wget fallback worked for an endpoint, fetch the pod's remaining endpoints via the fallback without retrying port-forward")
+	captureCmd.Flags().StringVar(&consulHTTPAddr, "consul-http-addr", "", "Consul HTTP API address (e.g. https://consul.example.com:8501) to read the service's config entries from into consul/ (default $CONSUL_HTTP_ADDR)")
+	captureCmd.Flags().StringVar(&consulToken, "consul-token", "", "ACL token for --consul-http-addr (default $CONSUL_HTTP_TOKEN)")
+	captureCmd.Flags().IntVar(&adminPort, "admin-port", defaultAdminPort, "Port of the Envoy admin API inside the pod")
+	captureCmd.Flags().StringVar(&adminURL, "admin-url", "", "Send admin requests to this URL, e.g. an already-running kubectl port-forward (http://127.0.0.1:19000), instead of port-forwarding")
+	captureCmd.Flags().StringVar(&adminUDS, "admin-uds", "", "Fetch admin endpoints over this Unix domain socket in the sidecar (e.g. /var/run/envoy/admin.sock) instead of the --admin-port")
 	captureCmd.Flags().StringVar(&outputDir, "output-dir", outputDir, "Directory to save snapshots")
-	captureCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Target namespace (optional)")
+	captureCmd.Flags().StringArrayVar(&annotateFrom, "annotate-from-annotation", nil, "Copy this pod annotation (or, if the pod lacks it, namespace annotation) into manifest.json metadata, e.g. argocd.argoproj.io/tracking-id (repeatable)")
+	captureCmd.Flags().StringVar(&outputPrefix, "output-prefix", "", "Prefix for snapshot directories and archives, e.g. an incident ID (recorded in manifest.json)")
+	captureCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Target namespace, or a comma-separated list of namespaces (optional)")
 	captureCmd.Flags().IntVar(&interval, "sleep", 5, "Sleep duration between captures in seconds (minimum 5s)")
 	captureCmd.Flags().IntVar(&duration, "duration", 60, "Total capture duration in seconds")
 	captureCmd.Flags().IntVar(&repeat, "repeat", 0, "Number of snapshot repetitions (takes precedence over duration)")
 	captureCmd.Flags().BoolVar(&enableTrace, "enable-trace", false, "Enable Envoy trace log level")
+	captureCmd.Flags().DurationVar(&traceMaxDuration, "trace-max-duration", 5*time.Minute, "With --enable-trace, reset the log level to info after this long even if the capture continues (0 disables)")
 	captureCmd.Flags().BoolVar(&tcpdumpEnabled, "tcpdump", false, "Enable tcpdump capture (runs once if enabled)")
+	captureCmd.Flags().BoolVar(&pcapToText, "pcap-to-text", false, "Summarize the captured pcaps (talkers, ports, TCP resets, retransmits, TLS alerts) into network/pcap-summary.txt")
+	captureCmd.Flags().StringVar(&tcpdumpMode, "tcpdump-mode", TcpdumpModeLogs, "How to retrieve the pcap: 'logs' (base64 via container logs) or 'file' (copy the pcap over exec)")
+	captureCmd.Flags().DurationVar(&rollingWindow, "rolling-window", 0, "Keep only the last window of logs and rotated pcaps (e.g. 5m), discarding older data to bound disk usage during long captures")
+	captureCmd.Flags().IntVar(&tcpdumpRotate, "tcpdump-rotate-seconds", 0, "Rotate the tcpdump capture into a new pcap every N seconds (slices are saved under network/)")
+	captureCmd.Flags().BoolVar(&bufferToDisk, "buffer-to-disk", true, "Write container logs to the snapshot as they stream in, keeping memory bounded; set to false to buffer each log in memory until the capture ends")
+	captureCmd.Flags().BoolVar(&perFileCompression, "compress-level-per-file", true, "Store already-compressed artifacts (pcaps, .gz) without recompressing them")
+	captureCmd.Flags().BoolVar(&statsUsedOnly, "stats-used-only", false, "Capture only stats that have been written to (adds usedonly to /stats, text or JSON format)")
+	captureCmd.Flags().StringArrayVar(&redactRegex, "redact-regex", nil, "Replace matches of this regular expression in endpoint output with REDACTED before writing (repeatable)")
+	captureCmd.Flags().BoolVar(&redactSecretsEnabled, "redact", true, "Replace TLS private keys, passwords and other inline secrets in /certs and /config_dump with REDACTED before writing")
+	captureCmd.Flags().BoolVar(&streamConfigDump, "stream-config-dump", false, "Write /config_dump straight to disk instead of buffering it in memory (skips the response shape check; not used with --dedup, --admin-uds, --redact-regex or --redact)")
+	captureCmd.Flags().IntVar(&compressConcurrency, "compress-concurrency", 0, "Compress archives with N parallel gzip workers (0 or 1: standard single-threaded gzip)")
+	captureCmd.Flags().StringSliceVar(&configDumpResourceNames, "config-dump-resources", nil, "Also capture /config_dump filtered per resource (e.g. listeners,clusters,routes) into envoy/config/")
+	captureCmd.Flags().BoolVar(&xdsStats, "xds-stats", false, "Also capture xDS/control-plane stats into xds-stats.txt and summarize them in manifest.json")
+	captureCmd.Flags().BoolVar(&bundleJSON, "bundle-json", false, "Also write every captured endpoint to endpoints.ndjson, one {endpoint, pod, ts, body} record per line, for Elasticsearch/Loki ingestion")
+	captureCmd.Flags().BoolVar(&initDump, "init-dump", false, "Also capture /init_dump (the init targets Envoy is waiting on) into init_dump.json; skipped on Envoys without it")
+	captureCmd.Flags().BoolVar(&statsPrometheus, "stats-prometheus", false, "Also capture /stats/prometheus (Prometheus text format) into stats_prometheus.txt, alongside /stats")
+	captureCmd.Flags().BoolVar(&recentLookups, "recent-lookups", false, "Also capture /stats/recentlookups (requires lookup tracking enabled in Envoy)")
+	captureCmd.Flags().StringVar(&maxSnapshotSize, "max-snapshot-size", "", "Trim the largest artifacts until each archive fits this size (e.g. 25Mi); trims are recorded in manifest.json")
+	captureCmd.Flags().BoolVar(&collectMetrics, "collect-prometheus-target", false, "Scrape the sidecar's Prometheus metrics endpoint into metrics.prom (port detected from the pod spec)")
+	captureCmd.Flags().BoolVar(&goroutineDump, "collect-goroutine-dump", false, "Save consul-dataplane's /debug/pprof/goroutine?debug=2 to dataplane-goroutines.txt (needs pprof exposed on a port named debug or pprof, or a pprof/debug address flag)")
+	captureCmd.Flags().BoolVar(&includeNodeInfo, "include-node-info", false, "Save the pod's node object (conditions, allocatable, taints) to k8s/node.json")
+	captureCmd.Flags().BoolVar(&resourceUsage, "resource-usage", false, "Save the pod's CPU and memory usage from metrics-server to k8s/resource-usage.json (skipped if metrics-server is not installed)")
+	captureCmd.Flags().DurationVar(&eventsSince, "events-since", 0, "Save the pod's Kubernetes events last seen within this window before the capture (e.g. 30m) to k8s/events.json")
+	captureCmd.Flags().IntVar(&metricsPort, "metrics-port", 0, "Prometheus metrics port to scrape (implies --collect-prometheus-target; default: detect, then 20200)")
+	captureCmd.Flags().StringVar(&baselinePath, "baseline", "", "Previous snapshot archive whose /stats is used to compute per-second rates into stats-rates.txt")
+	captureCmd.Flags().BoolVar(&dedup, "dedup", false, "In repeat mode, replace endpoint output unchanged since the previous iteration with a pointer file")
+	captureCmd.Flags().StringVar(&mtlsProbe, "mtls-probe", "", "After capture, run an openssl TLS handshake from the pod to this host:port and save the result to network/mtls-probe.txt")
+	captureCmd.Flags().StringVar(&expectFile, "expect", "", "Golden config_dump JSON to diff each captured /config_dump against (volatile fields ignored); deviations go to expected-diff.txt")
+	captureCmd.Flags().IntVar(&expectTolerance, "expect-tolerance", 0, "With --expect, the number of deviating fields allowed before the capture exits non-zero")
+	captureCmd.Flags().BoolVar(&perContainerNetns, "per-container-netns", false, "Record each container's own network namespace view (addresses, routes, sockets, admin reachability) under network/netns/<container>.txt, with a comparison (privileged ephemeral containers)")
+	captureCmd.Flags().BoolVar(&topology, "topology", false, "Write topology.json joining listeners, routes and clusters from the captured config_dump")
+	captureCmd.Flags().StringVar(&image, "image", "", "Image for the ephemeral containers and debug pods, e.g. a netshoot mirror in a private registry (default: $"+ephemeralImageEnv+", else "+kube.NetshootImage+")")
+	captureCmd.Flags().StringVar(&fallbackImage, "fallback-image", "", "Image with wget for the ephemeral endpoint-fetch fallback (default: the netshoot image)")
+	captureCmd.Flags().StringArrayVar(&ephemeralEnv, "ephemeral-env", nil, "Environment variable KEY=VALUE to set on injected ephemeral containers (repeatable)")
+	captureCmd.Flags().StringVar(&stateFile, "state-file", "", "Record completed pods and iterations in this file and resume from it on restart")
+	captureCmd.Flags().BoolVar(&watch, "watch", false, "Instead of capturing on an interval, watch the target pods and capture a pod whenever one of its containers restarts; runs until interrupted")
+	captureCmd.Flags().BoolVar(&captureCertsChain, "capture-certs-chain", false, "Decode the certificate chains in /config_dump, verify them against the trusted CA and write cert-chain-report.json")
+	captureCmd.Flags().StringVar(&certsCAFile, "certs-ca-file", "", "With --capture-certs-chain, verify against the CA certificates in this PEM file instead of the CAs configured in Envoy")
+	captureCmd.Flags().StringVar(&expectedSPIFFEID, "expected-spiffe-id", "", "With --capture-certs-chain, the exact SPIFFE ID the leaf certificate must carry (default: match the Envoy node's service)")
+	captureCmd.Flags().BoolVar(&drainTest, "drain-test", false, "After capturing, gracefully drain the sidecar's listeners (/drain_listeners?graceful) and record /listeners and /stats before and after. Disrupts traffic: pre-production only")
+	captureCmd.Flags().BoolVar(&outputDirOnly, "output-dir-only", false, "Write each pod's files to <pod>_snapshot/ in the snapshot directory and skip the archive, for pipelines that analyze the directory directly")
+	captureCmd.Flags().BoolVar(&singleArchive, "single-archive", false, "Bundle every pod of a capture round into one snapshot.tar.gz with per-pod <namespace>/<pod> directories and a combined manifest")
+	captureCmd.Flags().StringVar(&appendTar, "append-tar", "", "Append each pod's snapshot to this uncompressed .tar (created if missing) instead of writing one tarball per pod, growing one archive across sessions")
+	captureCmd.Flags().BoolVar(&appendTarGzip, "append-tar-gzip", false, "With --append-tar, also write a gzipped copy (<archive>.tar.gz) at the end of the session, keeping the .tar appendable")
+	captureCmd.Flags().BoolVar(&dryRunTar, "dry-run-tar", false, "List the files and sizes that would be archived instead of writing the tarball")
+	captureCmd.Flags().BoolVar(&keepTemp, "keep-temp", false, "Keep each snapshot's temporary directory (its path is logged) for inspection")
+	captureCmd.Flags().StringVar(&onComplete, "on-complete", "", "Command to run after each snapshot; supports templates like {{.TarPath}} and {{.PodName}}")
 
 	_ = viper.BindEnv("namespace", "KUBECTL_PLUGINS_CURRENT_NAMESPACE")
+	_ = viper.BindEnv(defaultEndpointsKey, "XDSNAP_DEFAULT_ENDPOINTS")
 	_ = viper.BindPFlag("namespace", captureCmd.Flags().Lookup("namespace"))
 
 	return captureCmd
 }
+
+// parseKeyValuePairs parses repeatable KEY=VALUE flag values into a map.
+func parseKeyValuePairs(pairs []string) (map[string]string, error) {
+	out := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %q", pair)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// defaultEndpointsKey lets an organization replace the built-in DefaultEndpoints
+// (e.g. via XDSNAP_DEFAULT_ENDPOINTS="/stats,/config_dump"). --endpoints still wins.
+const defaultEndpointsKey = "default-endpoints"
+
+// ephemeralImageEnv replaces kube.NetshootImage when --image is not set.
+const ephemeralImageEnv = "XDSNAP_IMAGE"
+
+// resolveEphemeralImage returns the --image value, else $XDSNAP_IMAGE. An empty
+// result keeps kube.NetshootImage.
+func resolveEphemeralImage(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return strings.TrimSpace(os.Getenv(ephemeralImageEnv))
+}
+
+// resolveDefaultEndpoints returns the configured default endpoint set, falling
+// back to the built-in DefaultEndpoints.
+func resolveDefaultEndpoints() []string {
+	var configured []string
+	for _, e := range strings.Split(viper.GetString(defaultEndpointsKey), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			configured = append(configured, e)
+		}
+	}
+	if len(configured) > 0 {
+		return configured
+	}
+	return append([]string{}, DefaultEndpoints...)
+}
+
+// captureTarget is one pod to capture and the service scoped to its namespace.
+type captureTarget struct {
+	Namespace string
+	Pod       string
+	Service   kube.KubernetesApiService
+}
+
+// key identifies the target across namespaces, e.g. in the --state-file.
+func (t captureTarget) key() string {
+	return t.Namespace + "/" + t.Pod
+}
+
+// probeEphemeralContainers checks each distinct service (one per namespace)
+// against its first target pod, since RBAC on pods/ephemeralcontainers can
+// differ between namespaces, and returns the services that must do without
+// ephemeral containers. A check that fails for another reason, such as a
+// timeout, is logged and the namespace keeps using them.
+func probeEphemeralContainers(targets []captureTarget) map[kube.KubernetesApiService]bool {
+	disabled := map[kube.KubernetesApiService]bool{}
+	probed := map[kube.KubernetesApiService]bool{}
+	for _, target := range targets {
+		if probed[target.Service] {
+			continue
+		}
+		probed[target.Service] = true
+		err := target.Service.CheckEphemeralContainers(target.Pod)
+		switch {
+		case err == nil:
+		case errors.Is(err, kube.ErrEphemeralUnavailable):
+			disabled[target.Service] = true
+			log.Printf("Warning: %v. Continuing without ephemeral containers in namespace %s: endpoints are fetched via port-forward only, and the Envoy log-level change is skipped.", err, target.Namespace)
+		default:
+			log.Printf("Warning: could not check ephemeral containers in namespace %s, using them anyway: %v", target.Namespace, err)
+		}
+	}
+	return disabled
+}
+
+// splitNamespaces parses -n as a comma-separated list, defaulting to "default".
+func splitNamespaces(value string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(value, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" && !containsString(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return []string{"default"}
+	}
+	return namespaces
+}
+
+// discoverPods resolves the pods to capture in one namespace: --pod, then
+// --deployment, then every connect-injected pod (optionally of one --service).
+// An empty result is logged, not an error, so other namespaces still run.
+func discoverPods(clientset *kubernetes.Clientset, kubeService kube.KubernetesApiService, namespace, podName, deployment, revision, serviceName string) ([]string, error) {
+	if podName != "" {
+		return []string{podName}, nil
+	}
+
+	if deployment != "" {
+		pods, err := kubeService.ListDeploymentPods(deployment, revision)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving pods for deployment %s in namespace %s: %w", deployment, namespace, err)
+		}
+		if len(pods) == 0 {
+			log.Printf("No pods found for deployment %s in namespace %s (revision=%s)", deployment, namespace, revision)
+		}
+		return pods, nil
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing pods in namespace %s: %w", namespace, err)
+	}
+	var names []string
+	for _, pod := range pods.Items {
+		if pod.Annotations["consul.hashicorp.com/connect-inject"] != "true" {
+			continue
+		}
+		if serviceName != "" && !hasConnectService(pod.Annotations, serviceName) {
+			continue
+		}
+		names = append(names, pod.Name)
+	}
+	if len(names) == 0 && serviceName != "" {
+		log.Printf("No connect-injected pods found for Consul service %s in namespace %s", serviceName, namespace)
+	} else if len(names) == 0 {
+		log.Printf("No pods found in namespace %s with the annotation consul.hashicorp.com/connect-inject=true", namespace)
+	}
+	return names, nil
+}
+
+// discoverNodePods finds the pods scheduled on node that run Envoy, i.e. have a
+// sidecar or gateway container, grouped by namespace. namespaces limits the
+// search; nil searches every namespace. The namespaces with matches are
+// returned in the order first seen.
+func discoverNodePods(clientset *kubernetes.Clientset, node string, namespaces []string) ([]string, map[string][]string, error) {
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing pods on node %s: %w", node, err)
+	}
+	var found []string
+	byNamespace := map[string][]string{}
+	for _, pod := range pods.Items {
+		if namespaces != nil && !containsString(namespaces, pod.Namespace) {
+			continue
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		envoy := ""
+		for _, c := range pod.Spec.Containers {
+			if role := kube.DetectContainerRole(c.Name); role == kube.RoleSidecar || role == kube.RoleGateway {
+				envoy = c.Name
+				break
+			}
+		}
+		if envoy == "" {
+			continue
+		}
+		if _, ok := byNamespace[pod.Namespace]; !ok {
+			found = append(found, pod.Namespace)
+		}
+		byNamespace[pod.Namespace] = append(byNamespace[pod.Namespace], pod.Name)
+		log.Printf("Found Envoy container %s in pod %s/%s on node %s", envoy, pod.Namespace, pod.Name, node)
+	}
+	if len(found) == 0 {
+		log.Printf("No pods with a sidecar or gateway container found on node %s", node)
+	}
+	return found, byNamespace, nil
+}
+
+// podIneligibleReason explains why a resolved pod fails the --require-annotation
+// or --require-ready filters, or returns "" if it may be captured.
+func podIneligibleReason(clientset *kubernetes.Clientset, namespace, podName string, annotations map[string]string, requireReady bool) (string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting pod: %w", err)
+	}
+	for key, value := range annotations {
+		if got, ok := pod.Annotations[key]; !ok || got != value {
+			return fmt.Sprintf("annotation %s=%s not set", key, value), nil
+		}
+	}
+	if requireReady && !podReady(pod) {
+		return "pod is not Ready", nil
+	}
+	return "", nil
+}
+
+// annotationMetadata copies the --annotate-from-annotation keys found on the pod
+// or, failing that, its namespace (e.g. an Argo CD or Flux revision) into the
+// manifest metadata. A namespace that cannot be read only loses its fallback.
+func annotationMetadata(clientset *kubernetes.Clientset, namespace, podName string, keys []string) (map[string]string, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting pod: %w", err)
+	}
+	var nsAnnotations map[string]string
+	if ns, err := clientset.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{}); err != nil {
+		log.Printf("Could not read annotations of namespace %s: %v", namespace, err)
+	} else {
+		nsAnnotations = ns.Annotations
+	}
+
+	metadata := map[string]string{}
+	for _, key := range keys {
+		if v, ok := pod.Annotations[key]; ok {
+			metadata[key] = v
+		} else if v, ok := nsAnnotations[key]; ok {
+			metadata[key] = v
+		}
+	}
+	return metadata, nil
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// pickBareEnvoyContainer selects the Envoy container of a --mesh none pod: the
+// --container if given, the only container, or one named "envoy".
+func pickBareEnvoyContainer(containers []string, name string) (string, error) {
+	switch {
+	case name != "":
+		if !containsString(containers, name) {
+			return "", fmt.Errorf("no container named %s", name)
+		}
+		return name, nil
+	case len(containers) == 1:
+		return containers[0], nil
+	case containsString(containers, "envoy"):
+		return "envoy", nil
+	}
+	return "", fmt.Errorf("cannot tell which of %s runs Envoy; pass --container", strings.Join(containers, ", "))
+}
+
+// extraLogs lists the containers whose logs are streamed besides the
+// application container. A bare Envoy is itself the capture container.
+func extraLogs(mesh, sidecar string) []string {
+	if mesh == MeshNone {
+		return nil
+	}
+	return []string{sidecar}
+}
+
+// hasConnectService reports whether a pod's consul.hashicorp.com/connect-service
+// annotation, a comma-separated list on multi-port pods, names service.
+func hasConnectService(annotations map[string]string, service string) bool {
+	for _, name := range strings.Split(annotations["consul.hashicorp.com/connect-service"], ",") {
+		if strings.TrimSpace(name) == service {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// fakeProbeKube answers CheckEphemeralContainers with err and counts the calls.
+type fakeProbeKube struct {
+	kube.KubernetesApiService
+
+	err   error
+	calls int
+}
+
+func (f *fakeProbeKube) CheckEphemeralContainers(pod string) error {
+	f.calls++
+	return f.err
+}
+
+func TestProbeEphemeralContainers(t *testing.T) {
+	allowed := &fakeProbeKube{}
+	forbidden := &fakeProbeKube{err: fmt.Errorf("%w: rbac: forbidden", kube.ErrEphemeralUnavailable)}
+	timedOut := &fakeProbeKube{err: errors.New("ephemeral containers dry-run failed: timeout")}
+	targets := []captureTarget{
+		{Namespace: "ns1", Pod: "web-1", Service: allowed},
+		{Namespace: "ns1", Pod: "web-2", Service: allowed},
+		{Namespace: "ns2", Pod: "api-1", Service: forbidden},
+		{Namespace: "ns3", Pod: "db-1", Service: timedOut},
+	}
+
+	disabled := probeEphemeralContainers(targets)
+
+	if disabled[allowed] || !disabled[forbidden] || disabled[timedOut] {
+		t.Errorf("disabled = ns1:%v ns2:%v ns3:%v, want only ns2", disabled[allowed], disabled[forbidden], disabled[timedOut])
+	}
+	for name, f := range map[string]*fakeProbeKube{"ns1": allowed, "ns2": forbidden, "ns3": timedOut} {
+		if f.calls != 1 {
+			t.Errorf("%s probed %d times, want 1", name, f.calls)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const certChainReportFileName = "cert-chain-report.json"
+
+// CertChainReport is cert-chain-report.json: every certificate chain in the
+// captured config_dump, verified against the trusted CA.
+type CertChainReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	// CASource is "config_dump" or the --certs-ca-file path.
+	CASource   string   `json:"ca_source"`
+	CASubjects []string `json:"ca_subjects,omitempty"`
+	// ExpectedSPIFFEID is --expected-spiffe-id; without it the leaf is matched
+	// against the Consul service from the bootstrap node (".../svc/<service>").
+	ExpectedSPIFFEID string           `json:"expected_spiffe_id,omitempty"`
+	ExpectedService  string           `json:"expected_service,omitempty"`
+	Chains           []CertChainEntry `json:"chains"`
+}
+
+// CertChainEntry is one distinct certificate chain and where it is configured.
+type CertChainEntry struct {
+	UsedBy       []string      `json:"used_by"`
+	Certificates []CertSummary `json:"certificates"`
+	Valid        bool          `json:"valid"`
+	Error        string        `json:"error,omitempty"`
+	LeafSPIFFEID string        `json:"leaf_spiffe_id,omitempty"`
+	// SPIFFEIDMatch is unset when there is nothing to compare against.
+	SPIFFEIDMatch   *bool     `json:"spiffe_id_match,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	DaysUntilExpiry int       `json:"days_until_expiry"`
+}
+
+// CertSummary describes one certificate of a chain, leaf first.
+type CertSummary struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	URIs      []string  `json:"uris,omitempty"`
+	IsCA      bool      `json:"is_ca"`
+}
+
+// loadCAFile reads the PEM certificates of a --certs-ca-file.
+func loadCAFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parsePEMCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// writeCertChainReport builds cert-chain-report.json from the captured
+// config_dump. A nil ca uses the trusted CAs configured in the dump.
+func writeCertChainReport(snapshotDir string, ca []*x509.Certificate, caFile, expectedID string) (*CertChainReport, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+
+	report := buildCertChainReport(doc, ca, caFile, expectedID, time.Now())
+	return report, writeJSON(filepath.Join(snapshotDir, certChainReportFileName), report)
+}
+
+func buildCertChainReport(doc any, ca []*x509.Certificate, caFile, expectedID string, now time.Time) *CertChainReport {
+	chains, trusted := configDumpCertificates(doc)
+
+	report := &CertChainReport{GeneratedAt: now, CASource: "config_dump", ExpectedSPIFFEID: expectedID}
+	if caFile != "" {
+		report.CASource = caFile
+	} else {
+		for _, pemData := range trusted {
+			certs, _ := parsePEMCertificates([]byte(pemData))
+			ca = append(ca, certs...)
+		}
+	}
+	roots := x509.NewCertPool()
+	for _, c := range ca {
+		roots.AddCert(c)
+		report.CASubjects = appendUnique(report.CASubjects, c.Subject.String())
+	}
+	if expectedID == "" {
+		if node := configDumpNode(doc); node != nil {
+			report.ExpectedService = node.Cluster
+		}
+	}
+
+	var pemChains []string
+	for pemData := range chains {
+		pemChains = append(pemChains, pemData)
+	}
+	sort.Strings(pemChains)
+	for _, pemData := range pemChains {
+		entry := CertChainEntry{UsedBy: chains[pemData]}
+		sort.Strings(entry.UsedBy)
+		certs, err := parsePEMCertificates([]byte(pemData))
+		if err == nil && len(certs) == 0 {
+			err = errors.New("no PEM certificates in chain")
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			report.Chains = append(report.Chains, entry)
+			continue
+		}
+		for _, c := range certs {
+			entry.Certificates = append(entry.Certificates, summarizeCert(c))
+		}
+
+		leaf := certs[0]
+		entry.ExpiresAt = leaf.NotAfter
+		entry.DaysUntilExpiry = int(leaf.NotAfter.Sub(now).Hours() / 24)
+		if len(ca) == 0 {
+			entry.Error = "no trusted CA to verify against"
+		} else {
+			intermediates := x509.NewCertPool()
+			for _, c := range certs[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := leaf.Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				CurrentTime:   now,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Valid = true
+			}
+		}
+
+		for _, u := range leaf.URIs {
+			if u.Scheme == "spiffe" {
+				entry.LeafSPIFFEID = u.String()
+				break
+			}
+		}
+		switch {
+		case expectedID != "":
+			match := entry.LeafSPIFFEID == expectedID
+			entry.SPIFFEIDMatch = &match
+		case report.ExpectedService != "" && entry.LeafSPIFFEID != "":
+			match := strings.HasSuffix(entry.LeafSPIFFEID, "/svc/"+report.ExpectedService)
+			entry.SPIFFEIDMatch = &match
+		}
+		report.Chains = append(report.Chains, entry)
+	}
+	return report
+}
+
+func summarizeCert(c *x509.Certificate) CertSummary {
+	s := CertSummary{
+		Subject:   c.Subject.String(),
+		Issuer:    c.Issuer.String(),
+		Serial:    c.SerialNumber.Text(16),
+		NotBefore: c.NotBefore,
+		NotAfter:  c.NotAfter,
+		IsCA:      c.IsCA,
+	}
+	for _, u := range c.URIs {
+		s.URIs = append(s.URIs, u.String())
+	}
+	return s
+}
+
+// configDumpCertificates walks the whole dump (listener and cluster transport
+// sockets, SDS secrets) for inline certificate_chain and trusted_ca data. Chains
+// map to the names of the resources that configure them.
+func configDumpCertificates(doc any) (chains map[string][]string, trusted []string) {
+	chains = map[string][]string{}
+	var walk func(v any, owner string)
+	walk = func(v any, owner string) {
+		switch t := v.(type) {
+		case map[string]any:
+			if isCertOwnerType(jsonString(t["@type"])) {
+				owner = jsonString(t["name"])
+			}
+			if pemData := inlineDataSource(t["certificate_chain"]); pemData != "" {
+				chains[pemData] = appendUnique(chains[pemData], owner)
+			}
+			if pemData := inlineDataSource(t["trusted_ca"]); pemData != "" && !containsString(trusted, pemData) {
+				trusted = append(trusted, pemData)
+			}
+			for _, child := range t {
+				walk(child, owner)
+			}
+		case []any:
+			for _, child := range t {
+				walk(child, owner)
+			}
+		}
+	}
+	walk(doc, "")
+	return chains, trusted
+}
+
+// isCertOwnerType reports whether a config_dump @type names a resource that
+// certificates are attributed to, rather than a nested filter or socket.
+func isCertOwnerType(typeURL string) bool {
+	for _, kind := range []string{".Listener", ".Cluster", ".Secret"} {
+		if strings.HasSuffix(typeURL, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// inlineDataSource returns the contents of an Envoy DataSource given inline;
+// file-based sources are not in the dump and yield "".
+func inlineDataSource(v any) string {
+	ds := jsonMap(v)
+	if s := jsonString(ds["inline_string"]); s != "" {
+		return s
+	}
+	if b := jsonString(ds["inline_bytes"]); b != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(b); err == nil {
+			return string(decoded)
+		}
+	}
+	return ""
+}
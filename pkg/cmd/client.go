@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/markcampv/xDSnap/kube"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newKubeClient builds a Kubernetes client, preferring in-cluster config and
+// falling back to KUBECONFIG.
+func newKubeClient() (*kubernetes.Clientset, *rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		log.Printf("Could not use in-cluster config, falling back to kubeconfig: %v", err)
+		configFlags := genericclioptions.NewConfigFlags(true)
+		kubeconfig := os.Getenv("KUBECONFIG")
+		configFlags.KubeConfig = &kubeconfig
+		restConfig, err := configFlags.ToRESTConfig()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating Kubernetes client config: %w", err)
+		}
+		config = restConfig
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+	}
+	return clientset, config, nil
+}
+
+// adminAuthOptions loads the --admin-auth-secret "namespace/name" credentials,
+// sent only to the admin port. It returns no options when ref is empty.
+func adminAuthOptions(clientset kubernetes.Interface, ref string, port int) ([]kube.ServiceOption, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	ns, name, ok := strings.Cut(ref, "/")
+	if !ok || ns == "" || name == "" {
+		return nil, fmt.Errorf("invalid --admin-auth-secret %q: expected namespace/name", ref)
+	}
+	auth, err := kube.LoadAdminBasicAuth(clientset, ns, name, port)
+	if err != nil {
+		return nil, err
+	}
+	return []kube.ServiceOption{kube.WithAdminBasicAuth(auth)}, nil
+}
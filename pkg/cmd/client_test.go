@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAdminAuthOptions(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy-admin", Namespace: "consul"},
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("s3cret")},
+	})
+
+	tests := []struct {
+		ref      string
+		wantOpts int
+		wantErr  string
+	}{
+		{ref: "", wantOpts: 0},
+		{ref: "consul/envoy-admin", wantOpts: 1},
+		{ref: "envoy-admin", wantErr: "expected namespace/name"},
+		{ref: "/envoy-admin", wantErr: "expected namespace/name"},
+		{ref: "consul/", wantErr: "expected namespace/name"},
+		{ref: "consul/absent", wantErr: "get secret consul/absent"},
+	}
+	for _, tt := range tests {
+		opts, err := adminAuthOptions(clientset, tt.ref, 19000)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("adminAuthOptions(%q) err = %v, want it to contain %q", tt.ref, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("adminAuthOptions(%q): %v", tt.ref, err)
+			continue
+		}
+		if len(opts) != tt.wantOpts {
+			t.Errorf("adminAuthOptions(%q) returned %d option(s), want %d", tt.ref, len(opts), tt.wantOpts)
+		}
+	}
+}
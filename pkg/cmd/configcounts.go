@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ConfigCounts are the resource counts of the captured /config_dump, for
+// spotting "0 clusters" or a config explosion without opening the dump.
+type ConfigCounts struct {
+	StaticListeners     int `json:"static_listeners"`
+	DynamicListeners    int `json:"dynamic_listeners"`
+	StaticRouteConfigs  int `json:"static_route_configs"`
+	DynamicRouteConfigs int `json:"dynamic_route_configs"`
+	// Routes counts the routes of every virtual host in every route config.
+	Routes          int `json:"routes"`
+	StaticClusters  int `json:"static_clusters"`
+	DynamicClusters int `json:"dynamic_clusters"`
+	// Endpoints counts the lb_endpoints of every cluster load assignment. It
+	// is only set when EDS was captured: in a /config_dump?include_eds or with
+	// --config-dump-resources endpoints.
+	Endpoints *int `json:"endpoints,omitempty"`
+}
+
+// configCounts reads the captured config_dump. It returns nil, nil when no
+// config_dump was captured.
+func configCounts(snapshotDir string) (*ConfigCounts, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+	counts := configDumpCounts(doc)
+	if counts.Endpoints == nil {
+		counts.Endpoints = edsResourceEndpoints(snapshotDir)
+	}
+	return counts, nil
+}
+
+// edsResourceEndpoints counts the endpoints of the filtered EDS dump written by
+// --config-dump-resources endpoints, or returns nil if there is none.
+func edsResourceEndpoints(snapshotDir string) *int {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, filepath.FromSlash(configDumpResourceDir), "endpoints.json"))
+	if err != nil {
+		return nil
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	// A resource-filtered dump lists the DynamicEndpointConfig entries directly.
+	n := 0
+	for _, e := range jsonSlice(jsonPath(doc, "configs")) {
+		n += lbEndpointCount(e)
+	}
+	return &n
+}
+
+// lbEndpointCount counts the lb_endpoints of one endpoint config entry.
+func lbEndpointCount(entry any) int {
+	n := 0
+	for _, locality := range jsonSlice(jsonPath(entry, "endpoint_config", "endpoints")) {
+		n += len(jsonSlice(jsonPath(locality, "lb_endpoints")))
+	}
+	return n
+}
+
+func configDumpCounts(doc any) *ConfigCounts {
+	c := &ConfigCounts{}
+
+	listeners := configDumpSection(doc, "ListenersConfigDump")
+	c.StaticListeners = len(jsonSlice(listeners["static_listeners"]))
+	c.DynamicListeners = len(jsonSlice(listeners["dynamic_listeners"]))
+
+	routes := configDumpSection(doc, "RoutesConfigDump")
+	c.StaticRouteConfigs = len(jsonSlice(routes["static_route_configs"]))
+	c.DynamicRouteConfigs = len(jsonSlice(routes["dynamic_route_configs"]))
+	for _, rc := range configDumpRouteConfigs(doc) {
+		for _, vh := range jsonSlice(rc["virtual_hosts"]) {
+			c.Routes += len(jsonSlice(jsonPath(vh, "routes")))
+		}
+	}
+
+	clusters := configDumpSection(doc, "ClustersConfigDump")
+	c.StaticClusters = len(jsonSlice(clusters["static_clusters"]))
+	c.DynamicClusters = len(jsonSlice(clusters["dynamic_active_clusters"])) + len(jsonSlice(clusters["dynamic_warming_clusters"]))
+
+	if endpoints := configDumpSection(doc, "EndpointsConfigDump"); endpoints != nil {
+		n := 0
+		for _, key := range []string{"static_endpoint_configs", "dynamic_endpoint_configs"} {
+			for _, e := range jsonSlice(endpoints[key]) {
+				n += lbEndpointCount(e)
+			}
+		}
+		c.Endpoints = &n
+	}
+	return c
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// Helpers for walking a decoded /config_dump document without binding to the
+// full Envoy protobuf schema.
+
+func jsonMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func jsonSlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func jsonString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// jsonPath follows a chain of object keys, returning nil if any step is missing.
+func jsonPath(v any, keys ...string) any {
+	for _, k := range keys {
+		m := jsonMap(v)
+		if m == nil {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// configDumpSection returns the config_dump entry whose @type ends with kind,
+// e.g. "ListenersConfigDump" or "ClustersConfigDump".
+func configDumpSection(doc any, kind string) map[string]any {
+	for _, c := range jsonSlice(jsonPath(doc, "configs")) {
+		section := jsonMap(c)
+		if strings.HasSuffix(jsonString(section["@type"]), "."+kind) {
+			return section
+		}
+	}
+	return nil
+}
+
+// configDumpListeners returns every listener config in the dump, static and
+// dynamic. Dynamic listeners use their active state when present and fall back
+// to the warming state.
+func configDumpListeners(doc any) []map[string]any {
+	section := configDumpSection(doc, "ListenersConfigDump")
+	var out []map[string]any
+	for _, l := range jsonSlice(section["static_listeners"]) {
+		if listener := jsonMap(jsonPath(l, "listener")); listener != nil {
+			out = append(out, listener)
+		}
+	}
+	for _, l := range jsonSlice(section["dynamic_listeners"]) {
+		listener := jsonMap(jsonPath(l, "active_state", "listener"))
+		if listener == nil {
+			listener = jsonMap(jsonPath(l, "warming_state", "listener"))
+		}
+		if listener != nil {
+			out = append(out, listener)
+		}
+	}
+	return out
+}
+
+// configDumpRouteConfigs returns every route configuration in the dump.
+func configDumpRouteConfigs(doc any) []map[string]any {
+	section := configDumpSection(doc, "RoutesConfigDump")
+	var out []map[string]any
+	for _, key := range []string{"static_route_configs", "dynamic_route_configs"} {
+		for _, r := range jsonSlice(section[key]) {
+			if rc := jsonMap(jsonPath(r, "route_config")); rc != nil {
+				out = append(out, rc)
+			}
+		}
+	}
+	return out
+}
+
+// configDumpClusters returns every cluster config in the dump.
+func configDumpClusters(doc any) []map[string]any {
+	section := configDumpSection(doc, "ClustersConfigDump")
+	var out []map[string]any
+	for _, key := range []string{"static_clusters", "dynamic_active_clusters", "dynamic_warming_clusters"} {
+		for _, c := range jsonSlice(section[key]) {
+			if cluster := jsonMap(jsonPath(c, "cluster")); cluster != nil {
+				out = append(out, cluster)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// configDumpResources maps friendly --config-dump-resources names to the
+// /config_dump?resource= field they select.
+var configDumpResources = map[string]string{
+	"listeners":        "dynamic_listeners",
+	"static-listeners": "static_listeners",
+	"clusters":         "dynamic_active_clusters",
+	"warming-clusters": "dynamic_warming_clusters",
+	"static-clusters":  "static_clusters",
+	"routes":           "dynamic_route_configs",
+	"scoped-routes":    "dynamic_scoped_route_configs",
+	"secrets":          "dynamic_active_secrets",
+	"endpoints":        "dynamic_endpoint_configs",
+}
+
+// configDumpResourceDir is where filtered config dumps are stored in the snapshot.
+const configDumpResourceDir = "envoy/config"
+
+// configDumpResourceEndpoints resolves friendly resource names to admin paths.
+func configDumpResourceEndpoints(names []string) ([]string, error) {
+	var endpoints []string
+	for _, name := range names {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		resource, ok := configDumpResources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown config_dump resource %q (valid: %s)", name, strings.Join(configDumpResourceNames(), ", "))
+		}
+		endpoint := "/config_dump?resource=" + resource
+		if name == "endpoints" {
+			// EDS is only included in the dump when explicitly requested
+			endpoint += "&include_eds"
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// configDumpResourceFileName returns envoy/config/<name>.json for a
+// /config_dump?resource= endpoint selecting one of configDumpResources, named
+// after its --config-dump-resources name, and false for any other endpoint.
+func configDumpResourceFileName(endpoint string) (string, bool) {
+	path, rawQuery, _ := strings.Cut(endpoint, "?")
+	if path != "/config_dump" {
+		return "", false
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", false
+	}
+	resource := query.Get("resource")
+	for name, field := range configDumpResources {
+		if field == resource {
+			return configDumpResourceDir + "/" + name + ".json", true
+		}
+	}
+	return "", false
+}
+
+func configDumpResourceNames() []string {
+	names := make([]string, 0, len(configDumpResources))
+	for name := range configDumpResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
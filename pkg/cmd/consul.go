@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// consulDir holds the Consul config entries captured with --consul-http-addr.
+const consulDir = "consul"
+
+// consulServiceEntryKinds are the config entries named after the service;
+// proxy-defaults is always named "global".
+var consulServiceEntryKinds = []string{"service-defaults", "service-resolver", "service-intentions"}
+
+// ConsulConfigClient reads config entries from the Consul HTTP API.
+type ConsulConfigClient struct {
+	Addr  string
+	Token string
+}
+
+// ConfigEntry returns the raw config entry, or nil, nil if it is not defined.
+func (c *ConsulConfigClient) ConfigEntry(kind, name string) ([]byte, error) {
+	u := strings.TrimSuffix(c.Addr, "/") + "/v1/config/" + url.PathEscape(kind) + "/" + url.PathEscape(name)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET /v1/config/%s/%s: %w", kind, name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read /v1/config/%s/%s: %w", kind, name, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("GET /v1/config/%s/%s -> %s: %s", kind, name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// captureConsulConfigEntries saves proxy-defaults and the service-defaults,
+// service-resolver and service-intentions of each service to
+// consul/<kind>-<name>.json. Entries that are not defined are logged.
+func captureConsulConfigEntries(client *ConsulConfigClient, services []string, destDir string) error {
+	dir := filepath.Join(destDir, consulDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	type entry struct{ kind, name string }
+	entries := []entry{{"proxy-defaults", "global"}}
+	for _, service := range services {
+		for _, kind := range consulServiceEntryKinds {
+			entries = append(entries, entry{kind, service})
+		}
+	}
+
+	var failed []string
+	for _, e := range entries {
+		data, err := client.ConfigEntry(e.kind, e.name)
+		if err != nil {
+			log.Printf("Failed to read Consul %s %s: %v", e.kind, e.name, err)
+			failed = append(failed, e.kind+"/"+e.name)
+			continue
+		}
+		if data == nil {
+			log.Printf("Consul %s %s is not defined", e.kind, e.name)
+			continue
+		}
+		path := filepath.Join(dir, e.kind+"-"+e.name+".json")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	if len(failed) == len(entries) {
+		return fmt.Errorf("no config entries could be read from %s", client.Addr)
+	}
+	return nil
+}
+
+// consulServiceNames returns the Consul services of the captured pod: its
+// connect-service annotation, or else the Envoy node's cluster, which Consul
+// sets to the service name.
+func consulServiceNames(snapshotDir string, node *NodeInfo) []string {
+	var names []string
+	if data, err := os.ReadFile(filepath.Join(snapshotDir, "pod.json")); err == nil {
+		var pod corev1.Pod
+		if json.Unmarshal(data, &pod) == nil {
+			for _, name := range strings.Split(pod.Annotations["consul.hashicorp.com/connect-service"], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	if len(names) == 0 && node != nil && node.Cluster != "" {
+		names = append(names, node.Cluster)
+	}
+	return names
+}
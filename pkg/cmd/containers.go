@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/markcampv/xDSnap/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewContainersCommand lists a pod's containers with their detected roles to help
+// pick the --container value for capture.
+func NewContainersCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	var podName, namespace string
+
+	cmd := &cobra.Command{
+		Use:   "containers",
+		Short: "List a pod's containers (including init and ephemeral) with detected roles",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if podName == "" {
+				return errors.New("--pod is required")
+			}
+			if namespace == "" {
+				namespace = "default"
+			}
+
+			clientset, config, err := newKubeClient()
+			if err != nil {
+				return err
+			}
+			kubeService := kube.NewKubernetesApiService(clientset, config, namespace)
+
+			infos, err := kubeService.DescribeContainers(podName)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "CONTAINER\tROLE\tIMAGE")
+			for _, c := range infos {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Role, c.Image)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&podName, "pod", "", "Pod name")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Target namespace (optional)")
+
+	return cmd
+}
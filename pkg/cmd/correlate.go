@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// correlationWindow is how long after a config update errors are attributed to it.
+const correlationWindow = 60 * time.Second
+
+// configUpdateEvent is one xDS resource update with the time Envoy applied it.
+type configUpdateEvent struct {
+	At      time.Time
+	Kind    string
+	Name    string
+	Version string
+	Source  string
+}
+
+// timedLogLine is a log line whose timestamp could be parsed.
+type timedLogLine struct {
+	At   time.Time
+	File string
+	Line string
+}
+
+var (
+	// [2024-05-01 12:00:01.123][14][error][upstream] ...
+	envoyLogLineRe = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d+)\]\[\d+\]\[(\w+)\]`)
+	// 2024-05-01T12:00:01.123Z [ERROR] ... (consul-dataplane / hclog)
+	hclogLineRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2}))\s+\[(\w+)\]`)
+	// Envoy debug lines announcing applied updates, e.g. "cds: add 3 cluster(s)".
+	xdsUpdateLogRe = regexp.MustCompile(`(?i)\b(cds|lds|rds|eds|sds): add|\b(cds|lds|rds): (loading|received) .*version`)
+)
+
+// ConfigChangeCorrelationRule flags config updates that are followed by a burst
+// of error logs, suggesting "config change at T likely caused errors at T+n".
+type ConfigChangeCorrelationRule struct{}
+
+func (r ConfigChangeCorrelationRule) ID() string { return "correlation.config_change_errors" }
+func (r ConfigChangeCorrelationRule) Evaluate(b *AnalyzeBundle) []Finding {
+	events := configDumpUpdateEvents(b.JSONDocs[endpointFileName("/config_dump")])
+	errLines, logEvents := scanTimedLogs(b.Logs)
+	events = append(events, logEvents...)
+	if len(events) == 0 || len(errLines) == 0 {
+		return nil
+	}
+
+	// Collapse updates applied within the same second (one xDS push) into a batch.
+	sort.Slice(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	var batches [][]configUpdateEvent
+	for _, e := range events {
+		if n := len(batches); n > 0 && e.At.Sub(batches[n-1][0].At) < time.Second {
+			batches[n-1] = append(batches[n-1], e)
+			continue
+		}
+		batches = append(batches, []configUpdateEvent{e})
+	}
+
+	var findings []Finding
+	for _, batch := range batches {
+		at := batch[0].At
+		var after []timedLogLine
+		before := 0
+		for _, l := range errLines {
+			switch {
+			case !l.At.Before(at) && l.At.Sub(at) <= correlationWindow:
+				after = append(after, l)
+			case l.At.Before(at) && at.Sub(l.At) <= correlationWindow:
+				before++
+			}
+		}
+		if len(after) < 3 || len(after) <= 2*before {
+			continue
+		}
+
+		delay := after[0].At.Sub(at).Round(time.Millisecond)
+		evidence := []Evidence{{
+			File:    batch[0].Source,
+			Pointer: at.Format(time.RFC3339Nano),
+			Snippet: describeUpdateBatch(batch),
+		}}
+		for _, l := range after[:min(len(after), 3)] {
+			evidence = append(evidence, Evidence{File: l.File, Pointer: l.At.Format(time.RFC3339Nano), Snippet: l.Line})
+		}
+
+		findings = append(findings, Finding{
+			ID:         r.ID() + "." + at.UTC().Format("20060102T150405"),
+			Title:      "Errors followed a configuration update",
+			Severity:   SeverityWarn,
+			Confidence: 0.6,
+			Summary: fmt.Sprintf("Config change at %s was followed by %d error log line(s) within %s (first after %s), versus %d in the %s before.",
+				at.UTC().Format(time.RFC3339), len(after), correlationWindow, delay, before, correlationWindow),
+			Hypothesis: "The configuration pushed at this time likely caused the errors that followed it.",
+			Evidence:   evidence,
+			RecommendedActions: []string{
+				"Review the config entries or service changes applied around the update time.",
+				"Compare the updated resources' version_info against the previous snapshot.",
+			},
+			Tags: []string{"correlation", "xds", "logs"},
+		})
+	}
+	return findings
+}
+
+// configDumpUpdateEvents lists dynamic listeners, clusters and route configs
+// with their last_updated time and version_info.
+func configDumpUpdateEvents(doc any) []configUpdateEvent {
+	if doc == nil {
+		return nil
+	}
+	source := endpointFileName("/config_dump")
+	var events []configUpdateEvent
+	add := func(kind string, entry map[string]any, resource any) {
+		at, err := time.Parse(time.RFC3339Nano, jsonString(entry["last_updated"]))
+		if err != nil {
+			return
+		}
+		events = append(events, configUpdateEvent{
+			At:      at,
+			Kind:    kind,
+			Name:    jsonString(jsonPath(resource, "name")),
+			Version: jsonString(entry["version_info"]),
+			Source:  source,
+		})
+	}
+
+	for _, l := range jsonSlice(configDumpSection(doc, "ListenersConfigDump")["dynamic_listeners"]) {
+		if state := jsonMap(jsonPath(l, "active_state")); state != nil {
+			add("listener", state, state["listener"])
+		}
+	}
+	for _, c := range jsonSlice(configDumpSection(doc, "ClustersConfigDump")["dynamic_active_clusters"]) {
+		entry := jsonMap(c)
+		add("cluster", entry, entry["cluster"])
+	}
+	for _, rc := range jsonSlice(configDumpSection(doc, "RoutesConfigDump")["dynamic_route_configs"]) {
+		entry := jsonMap(rc)
+		add("route", entry, entry["route_config"])
+	}
+	return events
+}
+
+// scanTimedLogs returns the error-level log lines and the xDS update lines found
+// in the captured logs, for lines whose timestamp can be parsed. Envoy's own
+// timestamps carry no zone and are read as UTC.
+func scanTimedLogs(logs map[string]string) (errLines []timedLogLine, updates []configUpdateEvent) {
+	for file, content := range logs {
+		for _, line := range strings.Split(content, "\n") {
+			at, level, ok := parseLogLinePrefix(line)
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(level) {
+			case "error", "critical", "err", "crit":
+				errLines = append(errLines, timedLogLine{At: at, File: file, Line: strings.TrimSpace(line)})
+			}
+			if xdsUpdateLogRe.MatchString(line) {
+				updates = append(updates, configUpdateEvent{At: at, Kind: "log", Source: file, Name: strings.TrimSpace(line)})
+			}
+		}
+	}
+	sort.Slice(errLines, func(i, j int) bool { return errLines[i].At.Before(errLines[j].At) })
+	return errLines, updates
+}
+
+func parseLogLinePrefix(line string) (time.Time, string, bool) {
+	if m := envoyLogLineRe.FindStringSubmatch(line); m != nil {
+		at, err := time.Parse("2006-01-02 15:04:05.999999999", m[1])
+		return at, m[2], err == nil
+	}
+	if m := hclogLineRe.FindStringSubmatch(line); m != nil {
+		at, err := time.Parse(time.RFC3339Nano, m[1])
+		if err != nil {
+			at, err = time.Parse("2006-01-02T15:04:05.999999999-0700", m[1])
+		}
+		return at, m[2], err == nil
+	}
+	return time.Time{}, "", false
+}
+
+func describeUpdateBatch(batch []configUpdateEvent) string {
+	var parts []string
+	for _, e := range batch[:min(len(batch), 5)] {
+		if e.Kind == "log" {
+			parts = append(parts, e.Name)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %s (version %s)", e.Kind, e.Name, valueOr(e.Version, "unknown")))
+	}
+	if len(batch) > 5 {
+		parts = append(parts, fmt.Sprintf("... and %d more", len(batch)-5))
+	}
+	return strings.Join(parts, "; ")
+}
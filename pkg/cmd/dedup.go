@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// dedupRefSuffix is appended to an endpoint file name for the pointer written in
+// place of content that is unchanged since an earlier snapshot.
+const dedupRefSuffix = ".ref.json"
+
+// DedupRef is the content of a pointer file written by --dedup.
+type DedupRef struct {
+	Endpoint       string    `json:"endpoint"`
+	SHA256         string    `json:"sha256"`
+	UnchangedSince time.Time `json:"unchanged_since"`
+	// Archive holds the full content, relative to --output-dir.
+	Archive string `json:"archive"`
+	File    string `json:"file"`
+}
+
+// EndpointDedup remembers the last full capture of each pod/endpoint across
+// repeat iterations. It is safe for concurrent use.
+type EndpointDedup struct {
+	mu   sync.Mutex
+	seen map[string]DedupRef
+}
+
+func NewEndpointDedup() *EndpointDedup {
+	return &EndpointDedup{seen: map[string]DedupRef{}}
+}
+
+// Check returns a pointer to the earlier capture when data is unchanged for this
+// pod and endpoint. Otherwise it records data as the new reference, stored in
+// archive, and returns nil.
+func (d *EndpointDedup) Check(pod, endpoint string, data []byte, archive string, capturedAt time.Time) *DedupRef {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	key := pod + "|" + endpoint
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if prev, ok := d.seen[key]; ok && prev.SHA256 == hash {
+		return &prev
+	}
+	d.seen[key] = DedupRef{
+		Endpoint:       endpoint,
+		SHA256:         hash,
+		UnchangedSince: capturedAt,
+		Archive:        archive,
+		File:           endpointFileName(endpoint),
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// drainDir holds the --drain-test artifacts.
+const drainDir = "drain"
+
+// drainSettle is how long to wait after triggering the drain before recording
+// the "after" state.
+const drainSettle = 5 * time.Second
+
+// drainState is /listeners and /stats recorded on one side of the drain.
+type drainState struct {
+	listeners []string
+	stats     map[string]int64
+}
+
+// runDrainTest records /listeners and /stats, POSTs /drain_listeners?graceful,
+// records them again and writes drain/comparison.txt. The drain stops the
+// sidecar's listeners from accepting traffic, so it only runs with --drain-test.
+func runDrainTest(kubeService kube.KubernetesApiService, config SnapshotConfig, destDir string) error {
+	dir := filepath.Join(destDir, drainDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	admin := newSnapshotAdminClient(kubeService, config)
+
+	record := func(phase string) (drainState, error) {
+		var state drainState
+		listeners, err := admin.Get("/listeners")
+		if err != nil {
+			return state, fmt.Errorf("%s: /listeners: %w", phase, err)
+		}
+		stats, err := admin.Get("/stats")
+		if err != nil {
+			return state, fmt.Errorf("%s: /stats: %w", phase, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, phase+"-listeners.txt"), listeners, 0o644); err != nil {
+			return state, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, phase+"-stats.txt"), stats, 0o644); err != nil {
+			return state, err
+		}
+		for _, line := range strings.Split(string(listeners), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				state.listeners = append(state.listeners, line)
+			}
+		}
+		state.stats = parseStatsText(string(stats))
+		return state, nil
+	}
+
+	before, err := record("before")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Draining listeners on pod %s (--drain-test)", config.PodName)
+	resp, err := admin.Post("/drain_listeners?graceful")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "drain-response.txt"), resp, 0o644); err != nil {
+		return err
+	}
+	time.Sleep(drainSettle)
+
+	after, err := record("after")
+	if err != nil {
+		return err
+	}
+	return writeDrainComparison(filepath.Join(dir, "comparison.txt"), before, after)
+}
+
+// writeDrainComparison lists the listeners that disappeared or appeared and
+// every stat whose value changed across the drain.
+func writeDrainComparison(path string, before, after drainState) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# listeners: %d before, %d after\n", len(before.listeners), len(after.listeners))
+	for _, l := range before.listeners {
+		if !containsString(after.listeners, l) {
+			fmt.Fprintf(&b, "- %s\n", l)
+		}
+	}
+	for _, l := range after.listeners {
+		if !containsString(before.listeners, l) {
+			fmt.Fprintf(&b, "+ %s\n", l)
+		}
+	}
+
+	var changed []string
+	for name, value := range after.stats {
+		if prev, ok := before.stats[name]; !ok || prev != value {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	fmt.Fprintf(&b, "# stats: %d changed\n", len(changed))
+	for _, name := range changed {
+		fmt.Fprintf(&b, "%s: %d -> %d\n", name, before.stats[name], after.stats[name])
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/markcampv/xDSnap/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// AdminIndexEntry is one endpoint listed by the Envoy admin /help index.
+type AdminIndexEntry struct {
+	Path        string
+	Description string
+	// Post marks mutating endpoints that only accept POST.
+	Post bool
+}
+
+// parseAdminIndex parses the /help index ("  /certs: print certs on machine",
+// "  /drain_listeners (POST): drain listeners"). Parameter lines and the
+// header are skipped.
+func parseAdminIndex(content string) []AdminIndexEntry {
+	var entries []AdminIndexEntry
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "/") {
+			continue
+		}
+		name, desc, _ := strings.Cut(line, ": ")
+		entry := AdminIndexEntry{Path: strings.TrimSuffix(name, ":"), Description: strings.TrimSpace(desc)}
+		if path, ok := strings.CutSuffix(entry.Path, " (POST)"); ok {
+			entry.Path, entry.Post = path, true
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// NewEndpointsCommand lists the admin endpoints a pod's Envoy serves, to help
+// build an --endpoints list for its version.
+func NewEndpointsCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	var podName, namespace, adminPathPrefix, adminAuthSecret string
+	var adminPort int
+	var includePost bool
+
+	cmd := &cobra.Command{
+		Use:   "endpoints",
+		Short: "List the Envoy admin endpoints available on a pod",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if podName == "" {
+				return errors.New("--pod is required")
+			}
+			if err := validateAdminPathPrefix(adminPathPrefix); err != nil {
+				return fmt.Errorf("invalid --admin-path-prefix: %w", err)
+			}
+			if adminPort < 1 || adminPort > 65535 {
+				return errors.New("--admin-port must be between 1 and 65535")
+			}
+			if namespace == "" {
+				namespace = "default"
+			}
+
+			clientset, config, err := newKubeClient()
+			if err != nil {
+				return err
+			}
+			authOpts, err := adminAuthOptions(clientset, adminAuthSecret, adminPort)
+			if err != nil {
+				return err
+			}
+			kubeService := kube.NewKubernetesApiService(clientset, config, namespace, append(authOpts, kube.WithImage(resolveEphemeralImage("")))...)
+
+			// No exec fallback: it would need the sidecar's name, and a pod whose
+			// port-forward fails is better served by `get --admin-uds`.
+			admin := NewEnvoyAdminClient(kubeService, podName, "", adminPort)
+			admin.ExecFallback = false
+			admin.Retries = 1
+			admin.PathPrefix = adminPathPrefix
+			data, err := admin.Get("/help")
+			if err != nil {
+				return fmt.Errorf("fetch admin index: %w", err)
+			}
+			entries := parseAdminIndex(string(data))
+			if len(entries) == 0 {
+				return errors.New("no endpoints found in the admin index")
+			}
+
+			w := tabwriter.NewWriter(streams.Out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ENDPOINT\tMETHOD\tDESCRIPTION")
+			for _, e := range entries {
+				method := "GET"
+				if e.Post {
+					if !includePost {
+						continue
+					}
+					method = "POST"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", e.Path, method, e.Description)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&podName, "pod", "", "Pod name")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Target namespace (optional)")
+	cmd.Flags().IntVar(&adminPort, "admin-port", defaultAdminPort, "Port of the Envoy admin API inside the pod")
+	cmd.Flags().StringVar(&adminPathPrefix, "admin-path-prefix", "", "Path prefix of a reverse-proxied admin API (e.g. /envoy-admin)")
+	cmd.Flags().StringVar(&adminAuthSecret, "admin-auth-secret", "", "Secret (namespace/name) with username and password keys for an admin API behind Basic Auth")
+	cmd.Flags().BoolVar(&includePost, "post", false, "Also list POST-only endpoints (these change Envoy state and are never captured)")
+
+	return cmd
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Exit codes reported with --strict-exit-code. Any other failure exits 1.
+const (
+	ExitOK         = 0
+	ExitPartial    = 2
+	ExitPermission = 3
+	ExitNoPods     = 4
+	ExitTimeout    = 5
+)
+
+// ExitError is returned by a command that wants main to exit with Code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string { return e.Err.Error() }
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// ExitCode maps a command error to the process exit code.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 1
+}
+
+// isPermissionError reports whether err is an RBAC or authentication rejection
+// from the API server.
+func isPermissionError(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)
+}
+
+// captureOutcomes aggregates the pod captures of a run for --strict-exit-code.
+type captureOutcomes struct {
+	forbidden []string
+	timedOut  []string
+	partial   []string
+}
+
+// record classifies one pod: a failed capture (permission or otherwise), a
+// capture that ran out of time, or one with failed endpoints.
+func (o *captureOutcomes) record(target string, result *CaptureResult, err error) {
+	switch {
+	case err != nil && isPermissionError(err):
+		o.forbidden = appendUnique(o.forbidden, target)
+	case err != nil:
+		o.partial = appendUnique(o.partial, target)
+	case result.Outcome == OutcomePodTimeout || result.Outcome == OutcomeDeadline || result.Outcome == OutcomeSkippedDeadline:
+		o.timedOut = appendUnique(o.timedOut, target)
+	case result.EndpointsFailed > 0:
+		o.partial = appendUnique(o.partial, target)
+	}
+}
+
+// exitError returns the most severe outcome as an *ExitError, preferring
+// permission over timeout over partial data, or nil if every pod succeeded.
+func (o *captureOutcomes) exitError() error {
+	switch {
+	case len(o.forbidden) > 0:
+		return &ExitError{Code: ExitPermission, Err: fmt.Errorf("permission denied capturing %s", strings.Join(o.forbidden, ", "))}
+	case len(o.timedOut) > 0:
+		return &ExitError{Code: ExitTimeout, Err: fmt.Errorf("capture timed out for %s", strings.Join(o.timedOut, ", "))}
+	case len(o.partial) > 0:
+		return &ExitError{Code: ExitPartial, Err: fmt.Errorf("partial capture for %s", strings.Join(o.partial, ", "))}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/markcampv/xDSnap/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// NewGetCommand fetches a single Envoy admin endpoint and prints it to stdout,
+// without logs, tcpdump or an archive.
+func NewGetCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	var podName, namespace, adminUDS, adminURL, adminPathPrefix, adminAuthSecret string
+	var adminPort int
+	var noEphemeral bool
+
+	cmd := &cobra.Command{
+		Use:   "get <endpoint>",
+		Short: "Fetch one Envoy admin endpoint from a pod and print it",
+		Example: `  kubectl xdsnap get /config_dump --pod dashboard-8bd546b69-m6v4q -n consul
+  kubectl xdsnap get "/stats?filter=upstream_cx" --pod dashboard-8bd546b69-m6v4q -n consul`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateAdminPathPrefix(adminPathPrefix); err != nil {
+				return fmt.Errorf("invalid --admin-path-prefix: %w", err)
+			}
+			if adminPort < 1 || adminPort > 65535 {
+				return errors.New("--admin-port must be between 1 and 65535")
+			}
+			if adminURL != "" {
+				if err := validateAdminURL(adminURL); err != nil {
+					return fmt.Errorf("invalid --admin-url: %w", err)
+				}
+			} else if podName == "" {
+				return errors.New("--pod or --admin-url is required")
+			}
+			if namespace == "" {
+				namespace = "default"
+			}
+			endpoint := args[0]
+			if !strings.HasPrefix(endpoint, "/") {
+				endpoint = "/" + endpoint
+			}
+
+			if adminURL != "" {
+				// No pod lookup: the URL already reaches the admin API.
+				admin := &EnvoyAdminClient{URL: adminURL, PathPrefix: adminPathPrefix}
+				data, err := admin.Get(endpoint)
+				if err != nil {
+					return err
+				}
+				_, err = streams.Out.Write(data)
+				return err
+			}
+
+			clientset, config, err := newKubeClient()
+			if err != nil {
+				return err
+			}
+			authOpts, err := adminAuthOptions(clientset, adminAuthSecret, adminPort)
+			if err != nil {
+				return err
+			}
+			kubeService := kube.NewKubernetesApiService(clientset, config, namespace, append(authOpts, kube.WithImage(resolveEphemeralImage("")))...)
+
+			containers, err := kubeService.ListContainers(podName)
+			if err != nil {
+				return err
+			}
+			sidecar, err := kubeService.PickSidecarContainer(podName, containers)
+			if err != nil {
+				return err
+			}
+
+			admin := NewEnvoyAdminClient(kubeService, podName, sidecar, adminPort)
+			admin.ExecFallback = !noEphemeral
+			admin.UDSPath, admin.UDSContainer = adminUDS, sidecar
+			admin.PathPrefix = adminPathPrefix
+			data, err := admin.Get(endpoint)
+			if err != nil {
+				return err
+			}
+			_, err = streams.Out.Write(data)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&podName, "pod", "", "Pod name")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Target namespace (optional)")
+	cmd.Flags().StringVar(&adminUDS, "admin-uds", "", "Fetch over this Unix domain socket in the sidecar instead of the --admin-port")
+	cmd.Flags().IntVar(&adminPort, "admin-port", defaultAdminPort, "Port of the Envoy admin API inside the pod")
+	cmd.Flags().StringVar(&adminURL, "admin-url", "", "Fetch from this URL, e.g. an already-running kubectl port-forward (http://127.0.0.1:19000)")
+	cmd.Flags().StringVar(&adminPathPrefix, "admin-path-prefix", "", "Path prefix of a reverse-proxied admin API (e.g. /envoy-admin)")
+	cmd.Flags().StringVar(&adminAuthSecret, "admin-auth-secret", "", "Secret (namespace/name) with username and password keys for an admin API behind Basic Auth")
+	cmd.Flags().BoolVar(&noEphemeral, "no-ephemeral", false, "Do not fall back to an ephemeral container when port-forward fails")
+
+	return cmd
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const expectedDiffFileName = "expected-diff.txt"
+
+// volatileConfigKeys change on every xDS push or differ per pod and are
+// ignored when comparing against --expect.
+var volatileConfigKeys = map[string]bool{
+	"version_info":        true,
+	"last_updated":        true,
+	"last_update_attempt": true,
+}
+
+// volatileConfigPaths are normalized paths ignored for the same reason: the
+// bootstrap node id and metadata name the individual pod.
+var volatileConfigPaths = []string{
+	"configs[BootstrapConfigDump].bootstrap.node.id",
+	"configs[BootstrapConfigDump].bootstrap.node.metadata",
+}
+
+// ExpectedDrift summarizes the deviations of a captured config_dump from the
+// --expect golden file.
+type ExpectedDrift struct {
+	Golden string `json:"golden"`
+	// Missing fields are in the golden file only, Unexpected ones in the
+	// capture only, and Changed ones differ in value.
+	Missing    int `json:"missing"`
+	Unexpected int `json:"unexpected"`
+	Changed    int `json:"changed"`
+	Tolerance  int `json:"tolerance"`
+}
+
+// Total is the number of deviating fields.
+func (d *ExpectedDrift) Total() int {
+	return d.Missing + d.Unexpected + d.Changed
+}
+
+// Exceeded reports whether the drift is over the tolerance.
+func (d *ExpectedDrift) Exceeded() bool {
+	return d.Total() > d.Tolerance
+}
+
+// loadExpectedConfig reads an --expect golden config_dump.
+func loadExpectedConfig(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// writeExpectedDiff compares the captured config_dump with golden and writes
+// every deviation to expected-diff.txt.
+func writeExpectedDiff(snapshotDir string, golden any, goldenPath string, tolerance int) (*ExpectedDrift, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+
+	drift := &ExpectedDrift{Golden: goldenPath, Tolerance: tolerance}
+	lines := diffConfigDumps(golden, doc, drift)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# expected: %s\n", goldenPath)
+	fmt.Fprintf(&b, "# %d missing, %d unexpected, %d changed (tolerance %d)\n", drift.Missing, drift.Unexpected, drift.Changed, tolerance)
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return drift, os.WriteFile(filepath.Join(snapshotDir, expectedDiffFileName), []byte(b.String()), 0o644)
+}
+
+// diffConfigDumps flattens both documents and returns one line per deviating
+// field ("- path: golden", "+ path: captured", "~ path: golden -> captured"),
+// counting them in drift.
+func diffConfigDumps(golden, captured any, drift *ExpectedDrift) []string {
+	want, got := flattenConfig(golden), flattenConfig(captured)
+
+	paths := make([]string, 0, len(want)+len(got))
+	for p := range want {
+		paths = append(paths, p)
+	}
+	for p := range got {
+		if _, ok := want[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, p := range paths {
+		w, inWant := want[p]
+		g, inGot := got[p]
+		switch {
+		case !inGot:
+			drift.Missing++
+			lines = append(lines, fmt.Sprintf("- %s: %s", p, w))
+		case !inWant:
+			drift.Unexpected++
+			lines = append(lines, fmt.Sprintf("+ %s: %s", p, g))
+		case w != g:
+			drift.Changed++
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", p, w, g))
+		}
+	}
+	return lines
+}
+
+// flattenConfig maps every leaf of a config_dump to its JSON value. List
+// elements are keyed by resource name or short @type rather than position,
+// so reordered listeners or clusters are not reported as drift.
+func flattenConfig(doc any) map[string]string {
+	out := map[string]string{}
+	var walk func(v any, path string)
+	walk = func(v any, path string) {
+		for _, p := range volatileConfigPaths {
+			if path == p {
+				return
+			}
+		}
+		switch t := v.(type) {
+		case map[string]any:
+			for k, child := range t {
+				if volatileConfigKeys[k] {
+					continue
+				}
+				walk(child, joinConfigPath(path, k))
+			}
+		case []any:
+			seen := map[string]int{}
+			for i, child := range t {
+				key := configElementKey(child)
+				if key == "" {
+					key = strconv.Itoa(i)
+				} else if n := seen[key]; n > 0 {
+					seen[key]++
+					key += "#" + strconv.Itoa(n)
+				} else {
+					seen[key] = 1
+				}
+				walk(child, path+"["+key+"]")
+			}
+		default:
+			b, _ := json.Marshal(t)
+			out[path] = string(b)
+		}
+	}
+	walk(doc, "")
+	return out
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// configElementKey identifies a list element by its resource name, looking
+// through the wrappers config_dump puts around dynamic resources, or else by
+// the short name of its @type.
+func configElementKey(v any) string {
+	m := jsonMap(v)
+	if m == nil {
+		return ""
+	}
+	for _, name := range []any{
+		m["name"],
+		jsonPath(m, "active_state", "listener", "name"),
+		jsonPath(m, "warming_state", "listener", "name"),
+		jsonPath(m, "cluster", "name"),
+		jsonPath(m, "route_config", "name"),
+		jsonPath(m, "secret", "name"),
+	} {
+		if s := jsonString(name); s != "" {
+			return s
+		}
+	}
+	if t := jsonString(m["@type"]); t != "" {
+		return t[strings.LastIndex(t, ".")+1:]
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// dataplaneGoroutinesFileName holds consul-dataplane's full goroutine dump.
+const dataplaneGoroutinesFileName = "dataplane-goroutines.txt"
+
+// goroutineDumpPath is the pprof goroutine profile with full stacks.
+const goroutineDumpPath = "/debug/pprof/goroutine?debug=2"
+
+// captureGoroutineDump saves the sidecar's goroutine dump from its pprof
+// endpoint. consul-dataplane is a Go binary, so when it hangs on the
+// control-plane side the stacks show what it is blocked on, which Envoy's
+// admin API cannot. The port is detected from the container spec.
+func captureGoroutineDump(kubeService kube.KubernetesApiService, config SnapshotConfig, destDir string) error {
+	container := sidecarContainer(config)
+	port, err := kubeService.DetectDebugPort(config.PodName, container)
+	if err != nil {
+		return err
+	}
+
+	data, err := kubeService.PortForwardGET(config.PodName, port, goroutineDumpPath)
+	if (err != nil || len(data) == 0) && !config.EphemeralDisabled {
+		data, err = kubeService.AdminGet(config.PodName, container, port, goroutineDumpPath)
+	}
+	if err != nil {
+		return fmt.Errorf("fetch :%d%s: %w", port, goroutineDumpPath, err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("fetch :%d%s returned no data", port, goroutineDumpPath)
+	}
+
+	if err := os.WriteFile(filepath.Join(destDir, dataplaneGoroutinesFileName), data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Captured goroutine dump (:%d) for %s\n", port, config.PodName)
+	return nil
+}
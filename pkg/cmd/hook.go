@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"text/template"
+)
+
+// parseOnCompleteHook compiles the --on-complete command template so a bad
+// template is rejected before any capture starts.
+func parseOnCompleteHook(command string) (*template.Template, error) {
+	return template.New("on-complete").Option("missingkey=error").Parse(command)
+}
+
+// runOnCompleteHook renders the --on-complete command against the capture
+// result and runs it through the platform shell.
+func runOnCompleteHook(command string, result *CaptureResult) error {
+	tmpl, err := parseOnCompleteHook(command)
+	if err != nil {
+		return fmt.Errorf("parse hook template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, result); err != nil {
+		return fmt.Errorf("render hook template: %w", err)
+	}
+
+	var hook *exec.Cmd
+	if runtime.GOOS == "windows" {
+		hook = exec.Command("cmd", "/C", rendered.String())
+	} else {
+		hook = exec.Command("sh", "-c", rendered.String())
+	}
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+
+	if err := hook.Run(); err != nil {
+		return fmt.Errorf("run %q: %w", rendered.String(), err)
+	}
+	return nil
+}
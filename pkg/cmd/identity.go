@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// identityFileName holds the pod's identity-related spec fields.
+const identityFileName = "k8s/identity.json"
+
+// PodIdentity is the part of the pod spec that decides which identity the
+// workload presents and how it resolves names. A wrong service account or
+// token audience is behind many "unauthorized" mesh errors.
+type PodIdentity struct {
+	ServiceAccount string `json:"service_account"`
+	// AutomountToken is the pod's automountServiceAccountToken, if set.
+	AutomountToken *bool `json:"automount_service_account_token,omitempty"`
+	// TokenProjections are the projected service account tokens and the
+	// audiences they are bound to.
+	TokenProjections []TokenProjection    `json:"token_projections,omitempty"`
+	DNSPolicy        string               `json:"dns_policy"`
+	DNSConfig        *corev1.PodDNSConfig `json:"dns_config,omitempty"`
+}
+
+// TokenProjection is a serviceAccountToken source of a projected volume.
+type TokenProjection struct {
+	Volume            string `json:"volume"`
+	Path              string `json:"path"`
+	Audience          string `json:"audience,omitempty"`
+	ExpirationSeconds *int64 `json:"expiration_seconds,omitempty"`
+}
+
+// writePodIdentity extracts the identity fields from pod.json into
+// k8s/identity.json and returns them for the manifest summary.
+func writePodIdentity(podJSON []byte, destDir string) (*PodIdentity, error) {
+	var pod corev1.Pod
+	if err := json.Unmarshal(podJSON, &pod); err != nil {
+		return nil, fmt.Errorf("parse pod: %w", err)
+	}
+	identity := podIdentity(&pod)
+
+	path := filepath.Join(destDir, filepath.FromSlash(identityFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return identity, writeJSON(path, identity)
+}
+
+func podIdentity(pod *corev1.Pod) *PodIdentity {
+	identity := &PodIdentity{
+		ServiceAccount: pod.Spec.ServiceAccountName,
+		AutomountToken: pod.Spec.AutomountServiceAccountToken,
+		DNSPolicy:      string(pod.Spec.DNSPolicy),
+		DNSConfig:      pod.Spec.DNSConfig,
+	}
+	if identity.ServiceAccount == "" {
+		identity.ServiceAccount = "default"
+	}
+	if identity.DNSPolicy == "" {
+		identity.DNSPolicy = string(corev1.DNSClusterFirst)
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Projected == nil {
+			continue
+		}
+		for _, src := range v.Projected.Sources {
+			if t := src.ServiceAccountToken; t != nil {
+				identity.TokenProjections = append(identity.TokenProjections, TokenProjection{
+					Volume:            v.Name,
+					Path:              t.Path,
+					Audience:          t.Audience,
+					ExpirationSeconds: t.ExpirationSeconds,
+				})
+			}
+		}
+	}
+	return identity
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const indexFileName = "index.json"
+
+// IndexEntry is one snapshot listed in the output directory's index.json.
+type IndexEntry struct {
+	PodName    string    `json:"pod_name"`
+	Namespace  string    `json:"namespace,omitempty"`
+	CapturedAt time.Time `json:"captured_at"`
+	Archive    string    `json:"archive"`
+	SizeBytes  int64     `json:"size_bytes"`
+	Outcome    string    `json:"outcome,omitempty"`
+	// ElapsedSeconds is how long the capture took, from start to archive.
+	ElapsedSeconds float64  `json:"elapsed_seconds,omitempty"`
+	KeyFindings    []string `json:"key_findings,omitempty"`
+}
+
+var indexMu sync.Mutex
+
+// appendSnapshotIndex adds the capture to outputDir/index.json so a session with
+// many tarballs stays browsable. The archive path is stored relative to outputDir.
+func appendSnapshotIndex(outputDir string, result *CaptureResult) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	path := filepath.Join(outputDir, indexFileName)
+	var entries []IndexEntry
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// An --output-dir-only capture is indexed by its directory.
+	archive := result.TarPath
+	if archive == "" {
+		archive = result.SnapshotDir
+	}
+	if rel, err := filepath.Rel(outputDir, archive); err == nil {
+		archive = filepath.ToSlash(rel)
+	}
+	entry := IndexEntry{
+		PodName:     result.PodName,
+		Namespace:   result.Namespace,
+		CapturedAt:  result.StartedAt,
+		Archive:     archive,
+		KeyFindings: result.KeyFindings,
+		Outcome:     result.Outcome,
+	}
+	if !result.CompletedAt.IsZero() {
+		entry.ElapsedSeconds = result.CompletedAt.Sub(result.StartedAt).Seconds()
+	}
+	if fi, err := os.Stat(result.TarPath); err == nil && !fi.IsDir() {
+		entry.SizeBytes = fi.Size()
+	}
+
+	return writeJSON(path, append(entries, entry))
+}
+
+// keyFindings runs the offline analyzer rules over a snapshot directory and
+// returns the IDs of warn and critical findings.
+func keyFindings(dir string) []string {
+	bundle, err := loadAnalyzeBundle(dir, dir)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, f := range runRules(bundle) {
+		if f.Severity == SeverityCritical || f.Severity == SeverityWarn {
+			ids = append(ids, f.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import "encoding/json"
+
+// unreadyInitTargets returns "<manager>: <target>" for every init target an
+// /init_dump response lists as not yet ready.
+func unreadyInitTargets(data []byte) ([]string, error) {
+	var dump struct {
+		UnreadyTargetsDumps []struct {
+			Name        string   `json:"name"`
+			TargetNames []string `json:"target_names"`
+		} `json:"unready_targets_dumps"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, manager := range dump.UnreadyTargetsDumps {
+		for _, target := range manager.TargetNames {
+			targets = append(targets, manager.Name+": "+target)
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/markcampv/xDSnap/kube"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	injectInitContainerPrefix = "consul-connect-inject-init"
+	injectInitSpecFileName    = "k8s/inject-init.json"
+	injectInitLogsFileName    = "logs/inject-init.txt"
+)
+
+// captureInjectInit saves the connect-inject init container's spec (command,
+// args, env) and logs from pod.json, which show how the iptables redirect was
+// set up. Pods without the init container are skipped silently.
+func captureInjectInit(kubeService kube.KubernetesApiService, podName string, podJSON []byte, destDir string) error {
+	var pod corev1.Pod
+	if err := json.Unmarshal(podJSON, &pod); err != nil {
+		return fmt.Errorf("parse pod: %w", err)
+	}
+
+	var initContainer *corev1.Container
+	for i := range pod.Spec.InitContainers {
+		if strings.HasPrefix(pod.Spec.InitContainers[i].Name, injectInitContainerPrefix) {
+			initContainer = &pod.Spec.InitContainers[i]
+			break
+		}
+	}
+	if initContainer == nil {
+		return nil
+	}
+
+	specPath := filepath.Join(destDir, filepath.FromSlash(injectInitSpecFileName))
+	if err := os.MkdirAll(filepath.Dir(specPath), 0o755); err != nil {
+		return err
+	}
+	if err := writeJSON(specPath, initContainer); err != nil {
+		return err
+	}
+
+	var logs bytes.Buffer
+	if err := kubeService.FetchContainerLogs(context.TODO(), podName, initContainer.Name, false, &logs); err != nil {
+		return fmt.Errorf("fetch %s logs: %w", initContainer.Name, err)
+	}
+	logsPath := filepath.Join(destDir, filepath.FromSlash(injectInitLogsFileName))
+	if err := os.MkdirAll(filepath.Dir(logsPath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(logsPath, logs.Bytes(), 0o644)
+}
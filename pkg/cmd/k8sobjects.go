@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// eventsFileName holds the pod's Kubernetes events.
+const eventsFileName = "k8s/events.json"
+
+// capturePodEvents saves the pod's events last seen at or after since.
+func capturePodEvents(kubeService kube.KubernetesApiService, podName string, since time.Time, destDir string) error {
+	data, err := kubeService.GetPodEvents(podName, since)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(destDir, filepath.FromSlash(eventsFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// networkPoliciesFileName holds the NetworkPolicies selecting the pod.
+const networkPoliciesFileName = "k8s/networkpolicies.json"
+
+// captureNetworkPolicies saves the NetworkPolicies whose podSelector matches the
+// pod, which often explain connection timeouts the Envoy dumps alone can't.
+func captureNetworkPolicies(kubeService kube.KubernetesApiService, podName, destDir string) error {
+	data, err := kubeService.GetNetworkPoliciesForPod(podName)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(destDir, filepath.FromSlash(networkPoliciesFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// k8sNodeFileName holds the Kubernetes node object the pod is scheduled on.
+const k8sNodeFileName = "k8s/node.json"
+
+// captureNode saves the node named by the pod's spec.nodeName, for correlating
+// mesh issues with node conditions, pressure and taints.
+func captureNode(kubeService kube.KubernetesApiService, podJSON []byte, destDir string) error {
+	var pod struct {
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(podJSON, &pod); err != nil {
+		return err
+	}
+	if pod.Spec.NodeName == "" {
+		return errors.New("pod is not scheduled to a node")
+	}
+	data, err := kubeService.GetNode(pod.Spec.NodeName)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(destDir, filepath.FromSlash(k8sNodeFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
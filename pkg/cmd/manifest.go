@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+const manifestFileName = "manifest.json"
+
+// ManifestSchemaVersion is bumped whenever a manifest field changes meaning or
+// is removed; parsers should accept manifests with an equal or lower version.
+const ManifestSchemaVersion = 1
+
+// Version is the xdsnap release, set at build time with
+// -ldflags "-X github.com/markcampv/xDSnap/pkg/cmd.Version=...".
+var Version = "dev"
+
+// toolVersion reports Version, or the module version for `go install` builds.
+func toolVersion() string {
+	if Version != "dev" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return Version
+}
+
+// Manifest is written as manifest.json at the root of every snapshot and
+// describes how the snapshot was produced.
+type Manifest struct {
+	SchemaVersion int          `json:"schema_version"`
+	Tool          ManifestTool `json:"tool"`
+	PodName       string       `json:"pod_name"`
+	Namespace     string       `json:"namespace,omitempty"`
+	Container     string       `json:"container,omitempty"`
+	IncidentID    string       `json:"incident_id,omitempty"`
+	// Metadata holds pod or namespace annotations copied with
+	// --annotate-from-annotation, tying the snapshot to a deployed revision.
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	CapturedAt time.Time         `json:"captured_at"`
+	// StatsCapturedAt is when /stats was fetched, used for --baseline rates.
+	StatsCapturedAt time.Time `json:"stats_captured_at,omitempty"`
+	Endpoints       []string  `json:"endpoints,omitempty"`
+	// Unavailable lists --optional-endpoints that answered 404.
+	Unavailable []string `json:"unavailable_endpoints,omitempty"`
+	// Flags are the command-line flags set explicitly for the capture.
+	Flags   map[string]string     `json:"flags,omitempty"`
+	Config  ManifestCaptureConfig `json:"config"`
+	Trimmed []TrimmedFile         `json:"trimmed,omitempty"`
+	// Pcaps lists the tcpdump captures and their decoded sizes.
+	Pcaps   []ManifestPcap  `json:"pcaps,omitempty"`
+	Summary ManifestSummary `json:"summary"`
+	// Interrupted is set when the capture was cut short, e.g. by Ctrl-C.
+	Interrupted bool `json:"interrupted,omitempty"`
+	// Readiness is the outcome of the --wait-ready gate.
+	Readiness *ManifestReadiness `json:"readiness,omitempty"`
+}
+
+// ManifestPcap is one tcpdump capture. Container is the ephemeral container
+// whose logs carried a streamed capture; Error is set when it could not be decoded.
+type ManifestPcap struct {
+	File      string `json:"file"`
+	Container string `json:"container,omitempty"`
+	Bytes     int64  `json:"bytes"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ManifestTool identifies the xdsnap build and the cluster it talked to.
+type ManifestTool struct {
+	Name              string `json:"name"`
+	Version           string `json:"version"`
+	KubeServerVersion string `json:"kube_server_version,omitempty"`
+}
+
+// ManifestCaptureConfig is the resolved capture configuration, after defaults
+// and auto-detection were applied.
+type ManifestCaptureConfig struct {
+	Mesh              string        `json:"mesh,omitempty"`
+	SidecarContainer  string        `json:"sidecar_container,omitempty"`
+	Duration          time.Duration `json:"duration_ns"`
+	EnableTrace       bool          `json:"enable_trace"`
+	Tcpdump           bool          `json:"tcpdump"`
+	TcpdumpMode       string        `json:"tcpdump_mode,omitempty"`
+	TcpdumpRotate     time.Duration `json:"tcpdump_rotate_ns,omitempty"`
+	RollingWindow     time.Duration `json:"rolling_window_ns,omitempty"`
+	EphemeralDisabled bool          `json:"ephemeral_disabled"`
+	EndpointsFirst    bool          `json:"endpoints_first"`
+	CaptureOrder      []string      `json:"capture_order"`
+	FailFast          bool          `json:"fail_fast"`
+	AdminPort         int           `json:"admin_port"`
+	AdminUDS          string        `json:"admin_uds,omitempty"`
+	AdminURL          string        `json:"admin_url,omitempty"`
+	MaxSnapshotSize   int64         `json:"max_snapshot_size,omitempty"`
+	Baseline          bool          `json:"baseline"`
+}
+
+// ManifestSummary holds values parsed from the captured artifacts for quick triage.
+type ManifestSummary struct {
+	XDS *XDSStatsSummary `json:"xds,omitempty"`
+	// Health counts listeners, clusters, unhealthy hosts and requests.
+	Health *HealthSummary `json:"health,omitempty"`
+	// Identity is the pod's service account, token audiences and DNS policy.
+	Identity *PodIdentity `json:"identity,omitempty"`
+	// Locality is the Envoy node's region/zone from the bootstrap.
+	Locality *NodeLocality `json:"locality,omitempty"`
+	// ConfigCounts counts the listeners, routes, clusters and endpoints in /config_dump.
+	ConfigCounts *ConfigCounts `json:"config_counts,omitempty"`
+	// Warming lists clusters and listeners not yet (fully) active.
+	Warming *WarmingSummary `json:"warming,omitempty"`
+	// ExpectedDrift counts deviations from the --expect golden config_dump.
+	ExpectedDrift *ExpectedDrift `json:"expected_drift,omitempty"`
+}
+
+func newManifest(config SnapshotConfig, result *CaptureResult) *Manifest {
+	return &Manifest{
+		SchemaVersion: ManifestSchemaVersion,
+		Tool: ManifestTool{
+			Name:              "xdsnap",
+			Version:           toolVersion(),
+			KubeServerVersion: config.KubeServerVersion,
+		},
+		PodName:    config.PodName,
+		Namespace:  config.Namespace,
+		Container:  config.ContainerName,
+		IncidentID: config.OutputPrefix,
+		Metadata:   config.Metadata,
+		CapturedAt: result.StartedAt,
+		Endpoints:  config.Endpoints,
+		Flags:      config.Flags,
+		Config: ManifestCaptureConfig{
+			Mesh:              config.Mesh,
+			SidecarContainer:  sidecarContainer(config),
+			Duration:          config.Duration,
+			EnableTrace:       config.EnableTrace,
+			Tcpdump:           config.TcpdumpEnabled,
+			TcpdumpMode:       config.TcpdumpMode,
+			TcpdumpRotate:     config.TcpdumpRotate,
+			RollingWindow:     config.RollingWindow,
+			EphemeralDisabled: config.EphemeralDisabled,
+			EndpointsFirst:    config.EndpointsFirst,
+			CaptureOrder:      captureOrder(config),
+			FailFast:          config.FailFast,
+			AdminPort:         snapshotAdminPort(config),
+			AdminUDS:          config.AdminUDS,
+			AdminURL:          redactURLUserinfo(config.AdminURL),
+			MaxSnapshotSize:   config.MaxSnapshotSize,
+			Baseline:          config.Baseline != nil,
+		},
+	}
+}
+
+func writeManifest(dir string, m *Manifest) error {
+	return writeJSON(filepath.Join(dir, manifestFileName), m)
+}
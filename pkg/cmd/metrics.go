@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+const metricsFileName = "metrics.prom"
+
+// captureMetrics scrapes the sidecar's Prometheus endpoint (separate from the
+// Envoy admin API) into metrics.prom. A zero port is detected from the pod spec.
+func captureMetrics(kubeService kube.KubernetesApiService, config SnapshotConfig, destDir string) error {
+	port, path := config.MetricsPort, "/metrics"
+	detectedPort, detectedPath, err := kubeService.DetectMetricsEndpoint(config.PodName)
+	if err != nil {
+		log.Printf("Could not detect metrics endpoint for pod %s: %v", config.PodName, err)
+	} else {
+		path = detectedPath
+		if port == 0 {
+			port = detectedPort
+		}
+	}
+	if port == 0 {
+		port = kube.DefaultMetricsPort
+	}
+
+	data, err := kubeService.PortForwardGET(config.PodName, port, path)
+	if (err != nil || len(data) == 0) && !config.EphemeralDisabled {
+		data, err = kubeService.AdminGet(config.PodName, config.ContainerName, port, path)
+	}
+	if err != nil {
+		return fmt.Errorf("scrape :%d%s: %w", port, path, err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("scrape :%d%s returned no data", port, path)
+	}
+
+	if err := os.WriteFile(filepath.Join(destDir, metricsFileName), data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Captured metrics (:%d%s) for %s\n", port, path, config.PodName)
+	return nil
+}
+
+// resourceUsageFileName holds the metrics-server PodMetrics for the pod.
+const resourceUsageFileName = "k8s/resource-usage.json"
+
+// captureResourceUsage saves the pod's CPU and memory usage from metrics-server.
+// A cluster without metrics-server is logged and skipped.
+func captureResourceUsage(kubeService kube.KubernetesApiService, podName, destDir string) error {
+	data, err := kubeService.GetPodMetrics(podName)
+	if errors.Is(err, kube.ErrMetricsUnavailable) {
+		log.Printf("Skipping resource usage for pod %s: %v", podName, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(destDir, filepath.FromSlash(resourceUsageFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+const mtlsProbeFileName = "network/mtls-probe.txt"
+
+// validateMTLSProbeTarget checks that --mtls-probe is a host:port pair.
+func validateMTLSProbeTarget(target string) error {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return fmt.Errorf("%q must be host:port", target)
+	}
+	return nil
+}
+
+// probeMTLS runs an openssl s_client handshake against target from an ephemeral
+// container in the captured pod's netns and saves the output, including the
+// presented certificate chain, to network/mtls-probe.txt. A failed handshake is
+// still written: the error output is usually what the probe is for.
+func probeMTLS(kubeService kube.KubernetesApiService, config SnapshotConfig, target, destDir string) error {
+	command := []string{"openssl", "s_client", "-connect", target, "-showcerts"}
+	if host, _, err := net.SplitHostPort(target); err == nil && net.ParseIP(host) == nil {
+		command = append(command, "-servername", host)
+	}
+
+	var stdout, stderr bytes.Buffer
+	runErr := kubeService.RunEphemeralInTargetNetNSWithOutput(
+		config.PodName,
+		config.ContainerName,
+		command,
+		false,
+		30*time.Second,
+		&stdout,
+		&stderr,
+	)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s from pod %s at %s\n", target, config.PodName, time.Now().Format(time.RFC3339))
+	if runErr != nil {
+		fmt.Fprintf(&b, "# probe error: %v\n", runErr)
+	}
+	b.WriteString("\n## stdout\n")
+	b.Write(stdout.Bytes())
+	b.WriteString("\n## stderr\n")
+	b.Write(stderr.Bytes())
+
+	path := filepath.Join(destDir, filepath.FromSlash(mtlsProbeFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// netnsDir holds the --per-container-netns views, one file per container.
+const netnsDir = "network/netns"
+
+// netnsComparisonFileName groups the pod's containers by network namespace.
+const netnsComparisonFileName = "network/netns/comparison.txt"
+
+// netnsViewScript prints the network namespace of the target container's PID 1
+// and, inside it, the addresses, routes, sockets and whether the admin API
+// answers. The ephemeral container shares the target's PID namespace, so
+// nsenter reaches the target's own netns even if it is not the pod's.
+const netnsViewScript = `echo "netns $(readlink /proc/1/ns/net)"
+nsenter -t 1 -n sh -c 'echo "## ip addr"; ip addr; echo "## ip route"; ip route; echo "## ss -tan"; ss -tan; echo "## admin :%d/ready"; curl -s -o /dev/null -w "%%{http_code}\n" --max-time 3 ` + kube.AdminAuthCurlArgs + ` http://127.0.0.1:%d/ready'`
+
+// netnsAdminScript fetches each admin path given as an argument from inside
+// the network namespace of the target container's PID 1, printing a
+// "=== <path>" line followed by the base64 response on one line.
+const netnsAdminScript = `for p in "$@"; do echo "=== $p"; nsenter -t 1 -n curl -s --max-time 10 ` + kube.AdminAuthCurlArgs + ` "http://127.0.0.1:%d$p" | base64 | tr -d '\n'; echo; done`
+
+// probeContainerNetns records each container's network view into
+// network/netns/<container>.txt and writes comparison.txt. Containers of a pod
+// normally share one netns; multi-interface setups that move a process into
+// its own show up as separate groups. It returns one container for every
+// namespace other than the sidecar's, in name order, for the per-namespace
+// tcpdump and admin fetches.
+func probeContainerNetns(kubeService kube.KubernetesApiService, config SnapshotConfig, destDir string) ([]string, error) {
+	containers, err := kubeService.ListContainers(config.PodName)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(destDir, filepath.FromSlash(netnsDir))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	port := snapshotAdminPort(config)
+	script := fmt.Sprintf(netnsViewScript, port, port)
+	groups := map[string][]string{}
+	for _, container := range containers {
+		var stdout, stderr bytes.Buffer
+		// nsenter needs CAP_SYS_ADMIN, hence privileged.
+		runErr := kubeService.RunEphemeralInTargetNetNSWithOutput(config.PodName, container, []string{"sh", "-c", script}, true, 30*time.Second, &stdout, &stderr)
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "# container %s of pod %s at %s\n", container, config.PodName, time.Now().Format(time.RFC3339))
+		if runErr != nil {
+			fmt.Fprintf(&b, "# error: %v\n", runErr)
+			log.Printf("Failed to record the network view of container %s: %v", container, runErr)
+		}
+		b.Write(stdout.Bytes())
+		if stderr.Len() > 0 {
+			b.WriteString("\n## stderr\n")
+			b.Write(stderr.Bytes())
+		}
+		if err := os.WriteFile(filepath.Join(dir, container+".txt"), b.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+
+		ns := netnsID(stdout.String())
+		if ns == "" {
+			ns = "unknown"
+		}
+		groups[ns] = append(groups[ns], container)
+	}
+	if err := writeNetnsComparison(filepath.Join(destDir, filepath.FromSlash(netnsComparisonFileName)), groups); err != nil {
+		return nil, err
+	}
+	return foreignNetnsContainers(groups, sidecarContainer(config)), nil
+}
+
+// foreignNetnsContainers picks the first container of every namespace that
+// does not hold the sidecar. Nothing is picked when the sidecar's namespace is
+// unknown, since then nothing can be told apart.
+func foreignNetnsContainers(groups map[string][]string, sidecar string) []string {
+	sidecarNS := ""
+	for id, members := range groups {
+		if containsString(members, sidecar) {
+			sidecarNS = id
+		}
+	}
+	if sidecarNS == "" || sidecarNS == "unknown" {
+		return nil
+	}
+	var foreign []string
+	for id, members := range groups {
+		if id != sidecarNS && id != "unknown" {
+			foreign = append(foreign, members[0])
+		}
+	}
+	sort.Strings(foreign)
+	return foreign
+}
+
+// netnsPcapFile is the tcpdump taken in a container's own network namespace.
+func netnsPcapFile(container string) string {
+	return path.Join(netnsDir, container+".pcap")
+}
+
+// captureNetnsAdmin fetches the capture's admin endpoints from inside the
+// network namespace of container into network/netns/<container>/, named as in
+// the snapshot root, so they can be compared with what the sidecar's
+// namespace sees. Responses go through the same redaction as the main fetches.
+func captureNetnsAdmin(kubeService kube.KubernetesApiService, config SnapshotConfig, container, destDir string) error {
+	paths := make([]string, len(config.Endpoints))
+	for i, endpoint := range config.Endpoints {
+		paths[i] = adminPath(config.AdminPathPrefix, endpoint)
+	}
+	script := fmt.Sprintf(netnsAdminScript, snapshotAdminPort(config))
+	var stdout, stderr bytes.Buffer
+	// The paths stay positional so they are never parsed by the shell.
+	command := append([]string{"sh", "-c", script, "sh"}, paths...)
+	if err := kubeService.RunEphemeralInTargetNetNSWithOutput(config.PodName, container, command, true, 2*time.Minute, &stdout, &stderr); err != nil {
+		return fmt.Errorf("%w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	responses := parseNetnsAdminOutput(stdout.String())
+	dir := filepath.Join(destDir, filepath.FromSlash(netnsDir), container)
+	for i, endpoint := range config.Endpoints {
+		encoded, ok := responses[paths[i]]
+		if !ok || encoded == "" {
+			log.Printf("No %s response in the network namespace of container %s", endpoint, container)
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Printf("Failed to decode %s from the network namespace of container %s: %v", endpoint, container, err)
+			continue
+		}
+		if config.RedactSecrets && isSecretEndpoint(endpoint) {
+			data, _ = redactSecrets(data)
+		}
+		data = redact(data, config.Redact)
+		file := filepath.Join(dir, filepath.FromSlash(endpointFileName(endpoint)))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseNetnsAdminOutput maps each path printed by netnsAdminScript to its
+// base64 response.
+func parseNetnsAdminOutput(output string) map[string]string {
+	responses := map[string]string{}
+	current := ""
+	for _, line := range strings.Split(output, "\n") {
+		if p, ok := strings.CutPrefix(line, "=== "); ok {
+			current = p
+			responses[current] = ""
+		} else if current != "" {
+			responses[current] += strings.TrimSpace(line)
+		}
+	}
+	return responses
+}
+
+// netnsID returns the "net:[inode]" link printed by netnsViewScript.
+func netnsID(output string) string {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "netns "); ok {
+			return strings.TrimSpace(id)
+		}
+	}
+	return ""
+}
+
+func writeNetnsComparison(path string, groups map[string][]string) error {
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	if len(ids) == 1 {
+		fmt.Fprintf(&b, "# all containers share %s\n", ids[0])
+	} else {
+		fmt.Fprintf(&b, "# %d network namespaces\n", len(ids))
+	}
+	for _, id := range ids {
+		fmt.Fprintf(&b, "%s: %s\n", id, strings.Join(groups[id], ", "))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
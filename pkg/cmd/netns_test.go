@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestForeignNetnsContainers(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups map[string][]string
+		want   []string
+	}{
+		{
+			name:   "shared namespace",
+			groups: map[string][]string{"net:[1]": {"app", "consul-dataplane"}},
+		},
+		{
+			name: "one container per other namespace",
+			groups: map[string][]string{
+				"net:[1]": {"consul-dataplane", "app"},
+				"net:[2]": {"worker-b", "worker-a"},
+				"net:[3]": {"cni-probe"},
+			},
+			want: []string{"cni-probe", "worker-b"},
+		},
+		{
+			name: "sidecar namespace unknown",
+			groups: map[string][]string{
+				"unknown": {"consul-dataplane"},
+				"net:[2]": {"app"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := foreignNetnsContainers(tt.groups, "consul-dataplane"); !slices.Equal(got, tt.want) {
+				t.Errorf("foreignNetnsContainers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNetnsAdminOutput(t *testing.T) {
+	output := "=== /ready\nTElWRQo=\n=== /stats?filter=a b\n\n=== /listeners\nYWJj\nZGVm\n"
+	got := parseNetnsAdminOutput(output)
+	want := map[string]string{
+		"/ready":            "TElWRQo=",
+		"/stats?filter=a b": "",
+		"/listeners":        "YWJjZGVm",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseNetnsAdminOutput() = %v, want %v", got, want)
+	}
+	for path, body := range want {
+		if got[path] != body {
+			t.Errorf("response for %s = %q, want %q", path, got[path], body)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const nodeFileName = "node.json"
+
+// NodeLocality is the Envoy node's locality from the bootstrap.
+type NodeLocality struct {
+	Region  string `json:"region,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	SubZone string `json:"sub_zone,omitempty"`
+}
+
+// NodeInfo is the bootstrap node identity written to node.json, surfacing the
+// locality and metadata used by zone-aware routing.
+type NodeInfo struct {
+	ID       string         `json:"id,omitempty"`
+	Cluster  string         `json:"cluster,omitempty"`
+	Locality *NodeLocality  `json:"locality,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// writeNodeInfo extracts the bootstrap node from the captured config_dump into
+// node.json. It returns nil, nil when no config_dump was captured.
+func writeNodeInfo(snapshotDir string) (*NodeInfo, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+
+	node := configDumpNode(doc)
+	if node == nil {
+		return nil, fmt.Errorf("no bootstrap node in config_dump")
+	}
+	return node, writeJSON(filepath.Join(snapshotDir, nodeFileName), node)
+}
+
+// configDumpNode returns bootstrap.node from the BootstrapConfigDump section.
+func configDumpNode(doc any) *NodeInfo {
+	node := jsonMap(jsonPath(configDumpSection(doc, "BootstrapConfigDump"), "bootstrap", "node"))
+	if node == nil {
+		return nil
+	}
+	info := &NodeInfo{
+		ID:       jsonString(node["id"]),
+		Cluster:  jsonString(node["cluster"]),
+		Metadata: jsonMap(node["metadata"]),
+	}
+	if locality := jsonMap(node["locality"]); locality != nil {
+		info.Locality = &NodeLocality{
+			Region:  jsonString(locality["region"]),
+			Zone:    jsonString(locality["zone"]),
+			SubZone: jsonString(locality["sub_zone"]),
+		}
+	}
+	return info
+}
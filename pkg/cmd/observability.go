@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const observabilityFileName = "observability.json"
+
+// Observability is the proxy's telemetry wiring, written to observability.json:
+// where access logs go, which stats sinks are configured and where traces are
+// sent. It is parsed from the bootstrap and listeners in /config_dump.
+type Observability struct {
+	// AdminAccessLogs log requests to the admin API itself.
+	AdminAccessLogs []TelemetryOutput `json:"admin_access_logs,omitempty"`
+	// AccessLogs are configured on listeners or their network filters
+	// (http_connection_manager, tcp_proxy).
+	AccessLogs         []TelemetryOutput `json:"access_logs,omitempty"`
+	StatsSinks         []TelemetryOutput `json:"stats_sinks,omitempty"`
+	StatsFlushInterval string            `json:"stats_flush_interval,omitempty"`
+	Tracing            *TelemetryOutput  `json:"tracing,omitempty"`
+}
+
+// TelemetryOutput is one access log, stats sink or tracer. Destination is a
+// file path, host:port, "cluster <name>", "stdout" or "stderr", when known.
+type TelemetryOutput struct {
+	Listener    string `json:"listener,omitempty"`
+	Filter      string `json:"filter,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// writeObservability extracts the telemetry wiring from the captured
+// config_dump into observability.json. It returns nil, nil when no config_dump
+// was captured.
+func writeObservability(snapshotDir string) (*Observability, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+	obs := configDumpObservability(doc)
+	return obs, writeJSON(filepath.Join(snapshotDir, observabilityFileName), obs)
+}
+
+func configDumpObservability(doc any) *Observability {
+	obs := &Observability{}
+	bootstrap := jsonMap(jsonPath(configDumpSection(doc, "BootstrapConfigDump"), "bootstrap"))
+
+	admin := jsonMap(bootstrap["admin"])
+	for _, l := range jsonSlice(admin["access_log"]) {
+		obs.AdminAccessLogs = append(obs.AdminAccessLogs, telemetryOutput(jsonMap(l)))
+	}
+	if path := jsonString(admin["access_log_path"]); path != "" {
+		obs.AdminAccessLogs = append(obs.AdminAccessLogs, TelemetryOutput{Destination: path})
+	}
+
+	for _, s := range jsonSlice(bootstrap["stats_sinks"]) {
+		obs.StatsSinks = append(obs.StatsSinks, telemetryOutput(jsonMap(s)))
+	}
+	obs.StatsFlushInterval = jsonString(bootstrap["stats_flush_interval"])
+	if http := jsonMap(jsonPath(bootstrap, "tracing", "http")); http != nil {
+		tracer := telemetryOutput(http)
+		obs.Tracing = &tracer
+	}
+
+	for _, listener := range configDumpListeners(doc) {
+		name := jsonString(listener["name"])
+		for _, l := range jsonSlice(listener["access_log"]) {
+			out := telemetryOutput(jsonMap(l))
+			out.Listener = name
+			obs.AccessLogs = append(obs.AccessLogs, out)
+		}
+		for _, fc := range jsonSlice(listener["filter_chains"]) {
+			for _, f := range jsonSlice(jsonMap(fc)["filters"]) {
+				filter := jsonString(jsonMap(f)["name"])
+				for _, l := range jsonSlice(jsonPath(f, "typed_config", "access_log")) {
+					out := telemetryOutput(jsonMap(l))
+					out.Listener, out.Filter = name, filter
+					obs.AccessLogs = append(obs.AccessLogs, out)
+				}
+			}
+		}
+	}
+	return obs
+}
+
+// telemetryOutput describes an access log, stats sink or tracer entry: a name
+// and a typed_config.
+func telemetryOutput(entry map[string]any) TelemetryOutput {
+	cfg := jsonMap(entry["typed_config"])
+	typ := jsonString(cfg["@type"])
+	out := TelemetryOutput{
+		Name: jsonString(entry["name"]),
+		Type: typ[strings.LastIndex(typ, ".")+1:],
+	}
+	switch {
+	case strings.HasSuffix(typ, ".StdoutAccessLog"):
+		out.Destination = "stdout"
+	case strings.HasSuffix(typ, ".StderrAccessLog"):
+		out.Destination = "stderr"
+	default:
+		out.Destination = telemetryDestination(cfg)
+	}
+	return out
+}
+
+// telemetryDestination finds where a typed_config sends its data, for the
+// common file, statsd, gRPC and tracer configs.
+func telemetryDestination(cfg map[string]any) string {
+	if path := jsonString(cfg["path"]); path != "" {
+		return path
+	}
+	if sa := jsonMap(jsonPath(cfg, "address", "socket_address")); sa != nil {
+		return fmt.Sprintf("%s:%v", jsonString(sa["address"]), sa["port_value"])
+	}
+	if pipe := jsonString(jsonPath(cfg, "address", "pipe", "path")); pipe != "" {
+		return pipe
+	}
+	for _, grpc := range []any{cfg["grpc_service"], jsonPath(cfg, "common_config", "grpc_service")} {
+		if cluster := jsonString(jsonPath(grpc, "envoy_grpc", "cluster_name")); cluster != "" {
+			return "cluster " + cluster
+		}
+		if target := jsonString(jsonPath(grpc, "google_grpc", "target_uri")); target != "" {
+			return target
+		}
+	}
+	for _, key := range []string{"tcp_cluster_name", "collector_cluster"} {
+		if cluster := jsonString(cfg[key]); cluster != "" {
+			return "cluster " + cluster
+		}
+	}
+	return ""
+}
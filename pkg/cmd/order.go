@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capture phases for --capture-order. The endpoint phases fetch the admin
+// endpoints they cover, logs starts the log streams and raises the Envoy log
+// level, and tcpdump runs the packet capture.
+const (
+	// PhaseConfig covers /config_dump and its per-resource variants.
+	PhaseConfig = "config"
+	// PhaseStats covers /stats in every form, such as --xds-stats.
+	PhaseStats = "stats"
+	// PhaseEndpoints covers every other admin endpoint.
+	PhaseEndpoints = "endpoints"
+	PhaseLogs      = "logs"
+	PhaseTcpdump   = "tcpdump"
+)
+
+// defaultCaptureOrder fetches /config_dump before logs and tcpdump start and the
+// other endpoints after tcpdump; endpointsFirstOrder is --endpoints-first.
+var (
+	defaultCaptureOrder = []string{PhaseConfig, PhaseLogs, PhaseTcpdump, PhaseStats, PhaseEndpoints}
+	endpointsFirstOrder = []string{PhaseConfig, PhaseStats, PhaseEndpoints, PhaseLogs, PhaseTcpdump}
+)
+
+// parseCaptureOrder validates a --capture-order list. Phases left out run
+// afterwards in their default order, so nothing is skipped.
+func parseCaptureOrder(phases []string) ([]string, error) {
+	var order []string
+	for _, phase := range phases {
+		phase = strings.ToLower(strings.TrimSpace(phase))
+		if !containsString(defaultCaptureOrder, phase) {
+			return nil, fmt.Errorf("unknown phase %q (want %s)", phase, strings.Join(defaultCaptureOrder, ", "))
+		}
+		if containsString(order, phase) {
+			return nil, fmt.Errorf("phase %q is listed twice", phase)
+		}
+		order = append(order, phase)
+	}
+	for _, phase := range defaultCaptureOrder {
+		if !containsString(order, phase) {
+			order = append(order, phase)
+		}
+	}
+	return order, nil
+}
+
+// captureOrder is the phase sequence of a capture.
+func captureOrder(config SnapshotConfig) []string {
+	switch {
+	case len(config.CaptureOrder) > 0:
+		return config.CaptureOrder
+	case config.EndpointsFirst:
+		return endpointsFirstOrder
+	}
+	return defaultCaptureOrder
+}
+
+// groupEndpointsByPhase assigns each endpoint to the phase that fetches it,
+// keeping their relative order.
+func groupEndpointsByPhase(endpoints []string) map[string][]string {
+	groups := map[string][]string{}
+	for _, endpoint := range endpoints {
+		phase := PhaseEndpoints
+		switch path, _, _ := strings.Cut(endpoint, "?"); {
+		case strings.HasPrefix(path, "/config_dump"):
+			phase = PhaseConfig
+		case path == "/stats" || strings.HasPrefix(path, "/stats/"):
+			phase = PhaseStats
+		}
+		groups[phase] = append(groups[phase], endpoint)
+	}
+	return groups
+}
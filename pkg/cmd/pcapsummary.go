@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapSummaryFileName is written by --pcap-to-text, relative to the snapshot.
+const pcapSummaryFileName = "network/pcap-summary.txt"
+
+// pcapSummaryTop caps the talker, port and reset tables.
+const pcapSummaryTop = 10
+
+// tlsAlertDescriptions names the TLS alerts most often seen in mesh mTLS failures.
+var tlsAlertDescriptions = map[byte]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	40:  "handshake_failure",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	112: "unrecognized_name",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+// pcapSummary accumulates the findings of one or more pcaps.
+type pcapSummary struct {
+	files       []string
+	packets     int
+	bytes       int64
+	undecodable int
+	// talkers and ports count packets and bytes per "src -> dst" host pair and
+	// per service port (the lower port of a connection).
+	talkers map[string]*pcapCounter
+	ports   map[string]*pcapCounter
+	// resets counts RSTs per "sender -> receiver" connection.
+	resets      map[string]int
+	resetTotal  int
+	retransmits int
+	// seqEnd is the highest sequence number sent on each directed connection,
+	// for spotting retransmitted payload.
+	seqEnd    map[string]uint32
+	tlsAlerts map[string]int
+}
+
+type pcapCounter struct {
+	Packets int
+	Bytes   int64
+}
+
+func newPcapSummary() *pcapSummary {
+	return &pcapSummary{
+		talkers:   map[string]*pcapCounter{},
+		ports:     map[string]*pcapCounter{},
+		resets:    map[string]int{},
+		seqEnd:    map[string]uint32{},
+		tlsAlerts: map[string]int{},
+	}
+}
+
+// writePcapSummary reads the decoded pcaps and writes talkers, ports, TCP
+// resets, retransmits and TLS alerts to network/pcap-summary.txt. Pcaps that
+// failed to decode are skipped.
+func writePcapSummary(snapshotDir string, pcaps []ManifestPcap) error {
+	summary := newPcapSummary()
+	var errs []error
+	for _, p := range pcaps {
+		if p.Error != "" {
+			continue
+		}
+		if err := summary.addFile(filepath.Join(snapshotDir, filepath.FromSlash(p.File))); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.File, err))
+			continue
+		}
+		summary.files = append(summary.files, p.File)
+	}
+	if len(summary.files) == 0 {
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+		return nil
+	}
+
+	path := filepath.Join(snapshotDir, filepath.FromSlash(pcapSummaryFileName))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := summary.write(f); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// addFile decodes one pcap or pcapng file.
+func (s *pcapSummary) addFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var source *gopacket.PacketSource
+	if r, err := pcapgo.NewReader(bufio.NewReader(f)); err == nil {
+		source = gopacket.NewPacketSource(r, r.LinkType())
+	} else {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		ng, ngErr := pcapgo.NewNgReader(bufio.NewReader(f), pcapgo.DefaultNgReaderOptions)
+		if ngErr != nil {
+			return fmt.Errorf("not a pcap or pcapng file: %w", err)
+		}
+		source = gopacket.NewPacketSource(ng, ng.LinkType())
+	}
+	source.DecodeOptions = gopacket.DecodeOptions{Lazy: true, NoCopy: true}
+
+	for {
+		packet, err := source.NextPacket()
+		if err != nil {
+			// Stop at the end, or at a truncated or corrupt record: a capture
+			// cut off mid-packet still has everything before it.
+			return nil
+		}
+		s.add(packet)
+	}
+}
+
+func (s *pcapSummary) add(packet gopacket.Packet) {
+	s.packets++
+	length := int64(packet.Metadata().Length)
+	s.bytes += length
+
+	var src, dst string
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		src, dst = ip.SrcIP.String(), ip.DstIP.String()
+	case *layers.IPv6:
+		src, dst = ip.SrcIP.String(), ip.DstIP.String()
+	default:
+		s.undecodable++
+		return
+	}
+	countPcap(s.talkers, src+" -> "+dst, length)
+
+	switch l4 := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		srcPort, dstPort := uint16(l4.SrcPort), uint16(l4.DstPort)
+		countPcap(s.ports, fmt.Sprintf("tcp/%d", servicePort(srcPort, dstPort)), length)
+		conn := fmt.Sprintf("%s -> %s", hostPort(src, srcPort), hostPort(dst, dstPort))
+		if l4.RST {
+			s.resetTotal++
+			s.resets[conn]++
+		}
+		if n := uint32(len(l4.Payload)); n > 0 {
+			end := l4.Seq + n
+			if last, ok := s.seqEnd[conn]; ok && !seqAfter(end, last) {
+				s.retransmits++
+			} else {
+				s.seqEnd[conn] = end
+			}
+			s.addTLSAlert(l4.Payload)
+		}
+	case *layers.UDP:
+		countPcap(s.ports, fmt.Sprintf("udp/%d", servicePort(uint16(l4.SrcPort), uint16(l4.DstPort))), length)
+	}
+}
+
+// addTLSAlert records a TLS alert record at the start of a TCP payload. Alerts
+// sent after the handshake are encrypted and only counted.
+func (s *pcapSummary) addTLSAlert(payload []byte) {
+	if len(payload) < 7 || payload[0] != 0x15 || payload[1] != 0x03 {
+		return
+	}
+	recordLen := int(payload[3])<<8 | int(payload[4])
+	if recordLen != 2 {
+		s.tlsAlerts["encrypted"]++
+		return
+	}
+	level := "warning"
+	if payload[5] == 2 {
+		level = "fatal"
+	}
+	name, ok := tlsAlertDescriptions[payload[6]]
+	if !ok {
+		name = fmt.Sprintf("alert_%d", payload[6])
+	}
+	s.tlsAlerts[level+" "+name]++
+}
+
+func (s *pcapSummary) write(w io.Writer) error {
+	fmt.Fprintf(w, "Files: %s\n", strings.Join(s.files, ", "))
+	fmt.Fprintf(w, "Packets: %d (%d bytes), %d without an IP layer\n", s.packets, s.bytes, s.undecodable)
+	fmt.Fprintf(w, "TCP resets: %d\n", s.resetTotal)
+	fmt.Fprintf(w, "TCP retransmits: %d\n", s.retransmits)
+	alerts := 0
+	for _, n := range s.tlsAlerts {
+		alerts += n
+	}
+	fmt.Fprintf(w, "TLS alerts: %d\n", alerts)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nTop talkers\nSRC -> DST\tPACKETS\tBYTES\n")
+	for _, key := range topPcapCounters(s.talkers) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", key, s.talkers[key].Packets, s.talkers[key].Bytes)
+	}
+	fmt.Fprintf(tw, "\nTop ports\nPORT\tPACKETS\tBYTES\n")
+	for _, key := range topPcapCounters(s.ports) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", key, s.ports[key].Packets, s.ports[key].Bytes)
+	}
+	if len(s.resets) > 0 {
+		fmt.Fprintf(tw, "\nTCP resets\nSENDER -> RECEIVER\tRESETS\n")
+		for _, key := range topCounts(s.resets, pcapSummaryTop) {
+			fmt.Fprintf(tw, "%s\t%d\n", key, s.resets[key])
+		}
+	}
+	if len(s.tlsAlerts) > 0 {
+		fmt.Fprintf(tw, "\nTLS alerts\nALERT\tCOUNT\n")
+		for _, key := range topCounts(s.tlsAlerts, len(s.tlsAlerts)) {
+			fmt.Fprintf(tw, "%s\t%d\n", key, s.tlsAlerts[key])
+		}
+	}
+	return tw.Flush()
+}
+
+func countPcap(m map[string]*pcapCounter, key string, length int64) {
+	c, ok := m[key]
+	if !ok {
+		c = &pcapCounter{}
+		m[key] = c
+	}
+	c.Packets++
+	c.Bytes += length
+}
+
+// topPcapCounters returns the keys with the most bytes, at most pcapSummaryTop.
+func topPcapCounters(m map[string]*pcapCounter) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]].Bytes != m[keys[j]].Bytes {
+			return m[keys[i]].Bytes > m[keys[j]].Bytes
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > pcapSummaryTop {
+		keys = keys[:pcapSummaryTop]
+	}
+	return keys
+}
+
+// topCounts returns the n keys with the highest counts.
+func topCounts(m map[string]int, n int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// servicePort guesses the listening side of a connection: the lower port.
+func servicePort(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func hostPort(ip string, port uint16) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("[%s]:%d", ip, port)
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// seqAfter reports whether TCP sequence number a is after b, allowing for wraparound.
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
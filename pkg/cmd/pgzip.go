@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// parallelGzipBlockSize is the amount of input compressed per worker task.
+const parallelGzipBlockSize = 1 << 20
+
+// parallelGzip compresses fixed-size blocks concurrently, each as its own gzip
+// member, and writes the members in order. The result is a standard
+// multi-member gzip stream, slightly larger than a single-member one, that any
+// gzip reader decodes. Output is deterministic for a given input and level.
+type parallelGzip struct {
+	level int
+	buf   []byte
+	sem   chan struct{}
+	queue chan chan gzipBlock
+	done  chan error
+	err   error
+}
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+func newParallelGzip(out io.Writer, level, concurrency int) *parallelGzip {
+	w := &parallelGzip{
+		level: level,
+		buf:   make([]byte, 0, parallelGzipBlockSize),
+		sem:   make(chan struct{}, concurrency),
+		queue: make(chan chan gzipBlock, concurrency),
+		done:  make(chan error, 1),
+	}
+	go func() {
+		var err error
+		for result := range w.queue {
+			block := <-result
+			if err == nil {
+				err = block.err
+			}
+			if err == nil {
+				_, err = out.Write(block.data)
+			}
+		}
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *parallelGzip) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			w.dispatch()
+		}
+	}
+	return written, nil
+}
+
+// dispatch hands the current block to a worker, keeping its place in the queue.
+func (w *parallelGzip) dispatch() {
+	data := w.buf
+	w.buf = make([]byte, 0, parallelGzipBlockSize)
+
+	result := make(chan gzipBlock, 1)
+	w.queue <- result
+	w.sem <- struct{}{}
+	go func() {
+		defer func() { <-w.sem }()
+		var out bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&out, w.level)
+		if err == nil {
+			_, err = gz.Write(data)
+		}
+		if err == nil {
+			err = gz.Close()
+		}
+		result <- gzipBlock{data: out.Bytes(), err: err}
+	}()
+}
+
+// Close compresses the final partial block and waits for every member to be
+// written. An empty stream still gets one (empty) member so it is valid gzip.
+func (w *parallelGzip) Close() error {
+	if w.queue == nil {
+		return w.err
+	}
+	w.dispatch()
+	close(w.queue)
+	w.err = <-w.done
+	w.queue = nil
+	return w.err
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// startProfiling starts a CPU profile and/or an execution trace of xdsnap
+// itself, for the hidden --cpu-profile and --trace flags. The returned stop
+// function writes them out; it is safe to call when neither was requested.
+func startProfiling(cpuProfile, traceFile string) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+		stops = nil
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, fmt.Errorf("create --cpu-profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				log.Printf("Failed to write CPU profile %s: %v", cpuProfile, err)
+				return
+			}
+			log.Printf("CPU profile written to %s", cpuProfile)
+		})
+	}
+
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("create --trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, fmt.Errorf("start trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			if err := f.Close(); err != nil {
+				log.Printf("Failed to write trace %s: %v", traceFile, err)
+				return
+			}
+			log.Printf("Execution trace written to %s", traceFile)
+		})
+	}
+	return stop, nil
+}
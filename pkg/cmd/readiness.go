@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// readinessPollInterval is the wait between --wait-ready probes.
+const readinessPollInterval = time.Second
+
+// envoyStateLive is the /server_info state of a proxy that finished initializing.
+const envoyStateLive = "LIVE"
+
+// ManifestReadiness records the --wait-ready gate: whether the proxy reported
+// ready and how long the capture waited for it.
+type ManifestReadiness struct {
+	Ready         bool    `json:"ready"`
+	WaitedSeconds float64 `json:"waited_seconds"`
+	// State is the last /server_info state seen, with --wait-ready-live.
+	State string `json:"state,omitempty"`
+	// Error is the last probe failure when the proxy never became ready.
+	Error string `json:"error,omitempty"`
+}
+
+// waitEnvoyReady polls /ready, and with requireLive also the /server_info
+// state, until the proxy reports ready or timeout passes. It never fails the
+// capture: a proxy that stays unready is captured as-is and the outcome
+// recorded. Cancelling ctx ends the wait early.
+func waitEnvoyReady(ctx context.Context, admin *EnvoyAdminClient, timeout time.Duration, requireLive bool) *ManifestReadiness {
+	start := time.Now()
+	readiness := &ManifestReadiness{}
+	for {
+		state, err := probeEnvoyReady(admin, requireLive)
+		readiness.State = state
+		readiness.WaitedSeconds = time.Since(start).Seconds()
+		if err == nil {
+			readiness.Ready = true
+			readiness.Error = ""
+			return readiness
+		}
+		readiness.Error = err.Error()
+		if time.Since(start)+readinessPollInterval > timeout {
+			return readiness
+		}
+		select {
+		case <-ctx.Done():
+			return readiness
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
+
+// probeEnvoyReady checks /ready once, then /server_info if requireLive is set.
+func probeEnvoyReady(admin *EnvoyAdminClient, requireLive bool) (string, error) {
+	body, err := admin.Get("/ready")
+	if err != nil {
+		var statusErr *kube.HTTPStatusError
+		if errors.As(err, &statusErr) {
+			// Envoy answers 503 with its state (e.g. PRE_INITIALIZING) until it is LIVE.
+			return "", fmt.Errorf("/ready: %s %s", statusErr.Status, statusErr.Body)
+		}
+		return "", fmt.Errorf("/ready: %w", err)
+	}
+	if !requireLive {
+		return "", nil
+	}
+
+	data, err := admin.Get(ServerInfoEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ServerInfoEndpoint, err)
+	}
+	var info struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("parse %s: %w", ServerInfoEndpoint, err)
+	}
+	if info.State != envoyStateLive {
+		return info.State, fmt.Errorf("%s state is %s (/ready answered %q)", ServerInfoEndpoint, valueOr(info.State, "unknown"), strings.TrimSpace(string(body)))
+	}
+	return info.State, nil
+}
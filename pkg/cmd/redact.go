@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces every --redact-regex match and, with --redact, every
+// secret in captured endpoint output.
+const redactedValue = "REDACTED"
+
+// compileRedactPatterns compiles the --redact-regex patterns once per run.
+func compileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// redact replaces every match of patterns in data with redactedValue.
+func redact(data []byte, patterns []*regexp.Regexp) []byte {
+	for _, re := range patterns {
+		data = re.ReplaceAllLiteral(data, []byte(redactedValue))
+	}
+	return data
+}
+
+// secretEndpoints are the admin endpoints whose output can carry key material:
+// /config_dump holds SDS secrets and inline TLS contexts, /certs the loaded
+// certificates. Query strings (e.g. /config_dump?resource=) do not matter.
+var secretEndpoints = []string{"/certs", "/config_dump"}
+
+// secretJSONKeys are fields whose whole value is replaced by --redact: TLS
+// private keys, passwords, session ticket keys and generic secrets.
+var secretJSONKeys = map[string]bool{
+	"private_key":         true,
+	"password":            true,
+	"session_ticket_keys": true,
+	"generic_secret":      true,
+	"hmac_secret":         true,
+	"client_secret":       true,
+}
+
+// privateKeyPEM matches PEM-encoded private keys wherever they appear.
+var privateKeyPEM = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+// isSecretEndpoint reports whether endpoint is redacted by --redact.
+func isSecretEndpoint(endpoint string) bool {
+	path, _, _ := strings.Cut(endpoint, "?")
+	return containsString(secretEndpoints, path)
+}
+
+// redactSecrets replaces private keys and inline secrets in a JSON endpoint
+// body with redactedValue, keeping the rest of the document intact. It returns
+// data unchanged when nothing was redacted; a body that is not JSON only has
+// its PEM private keys replaced.
+func redactSecrets(data []byte) ([]byte, int) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		n := len(privateKeyPEM.FindAllIndex(data, -1))
+		if n == 0 {
+			return data, 0
+		}
+		return privateKeyPEM.ReplaceAllLiteral(data, []byte(redactedValue)), n
+	}
+
+	doc, n := redactSecretValues(doc)
+	if n == 0 {
+		return data, 0
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return data, 0
+	}
+	return buf.Bytes(), n
+}
+
+// redactSecretValues walks a decoded JSON value and returns it with every
+// secret replaced, along with the number of replacements.
+func redactSecretValues(v any) (any, int) {
+	switch v := v.(type) {
+	case map[string]any:
+		total := 0
+		for key, child := range v {
+			if secretJSONKeys[strings.ToLower(key)] {
+				if child != nil {
+					v[key] = redactedValue
+					total++
+				}
+				continue
+			}
+			redacted, n := redactSecretValues(child)
+			v[key] = redacted
+			total += n
+		}
+		return v, total
+	case []any:
+		total := 0
+		for i, child := range v {
+			redacted, n := redactSecretValues(child)
+			v[i] = redacted
+			total += n
+		}
+		return v, total
+	case string:
+		if privateKeyPEM.MatchString(v) {
+			return privateKeyPEM.ReplaceAllLiteralString(v, redactedValue), 1
+		}
+	}
+	return v, 0
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// rollingSegmentsPerWindow is how many segments a --rolling-window is cut
+// into; the oldest segment is dropped whole, so up to one segment more than
+// the window is kept.
+const rollingSegmentsPerWindow = 10
+
+// rollingWriter keeps the last window of a line-oriented stream, such as a
+// container log. Data is written to time-sliced segments, in memory or, with
+// a directory, on disk, and segments that ended more than window ago are
+// dropped as new data arrives. Segments are only cut at line boundaries.
+type rollingWriter struct {
+	window   time.Duration
+	slice    time.Duration
+	dir      string
+	segments []*rollingSegment
+	// pendingCut is set when a segment is due to be cut at the next newline.
+	pendingCut bool
+	dropped    int64
+	next       int
+}
+
+type rollingSegment struct {
+	start time.Time
+	buf   bytes.Buffer
+	file  *os.File
+	size  int64
+}
+
+// newRollingWriter keeps segments in memory, or under dir if it is not empty.
+func newRollingWriter(window time.Duration, dir string) *rollingWriter {
+	slice := window / rollingSegmentsPerWindow
+	if slice < time.Second {
+		slice = time.Second
+	}
+	return &rollingWriter{window: window, slice: slice, dir: dir}
+}
+
+func (w *rollingWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	w.prune(now)
+	written := 0
+	for len(p) > 0 {
+		seg := w.current()
+		if seg == nil {
+			var err error
+			if seg, err = w.cut(now); err != nil {
+				return written, err
+			}
+		} else if seg.size > 0 && now.Sub(seg.start) >= w.slice {
+			w.pendingCut = true
+		}
+		chunk := p
+		if w.pendingCut {
+			// Finish the current line in the old segment, then start a new one.
+			if i := bytes.IndexByte(p, '\n'); i >= 0 {
+				chunk = p[:i+1]
+			}
+		}
+		if err := seg.write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+		if w.pendingCut && len(chunk) > 0 && chunk[len(chunk)-1] == '\n' {
+			w.pendingCut = false
+			if _, err := w.cut(now); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *rollingWriter) current() *rollingSegment {
+	if len(w.segments) == 0 {
+		return nil
+	}
+	return w.segments[len(w.segments)-1]
+}
+
+// cut starts a new segment.
+func (w *rollingWriter) cut(now time.Time) (*rollingSegment, error) {
+	seg := &rollingSegment{start: now}
+	if w.dir != "" {
+		f, err := os.Create(filepath.Join(w.dir, fmt.Sprintf("segment-%06d", w.next)))
+		if err != nil {
+			return nil, err
+		}
+		seg.file = f
+	}
+	w.next++
+	w.segments = append(w.segments, seg)
+	return seg, nil
+}
+
+// prune drops segments whose data is all older than the window: a segment
+// ends where the next one starts.
+func (w *rollingWriter) prune(now time.Time) {
+	for len(w.segments) > 1 && now.Sub(w.segments[1].start) > w.window {
+		w.dropped += w.segments[0].size
+		w.segments[0].discard()
+		w.segments = w.segments[1:]
+	}
+}
+
+// finish drops what fell out of the window, writes the remaining segments to
+// dst in order and releases them. It returns the number of bytes dropped.
+func (w *rollingWriter) finish(dst io.Writer) (int64, error) {
+	w.prune(time.Now())
+	defer func() {
+		for _, seg := range w.segments {
+			seg.discard()
+		}
+		w.segments = nil
+	}()
+	for _, seg := range w.segments {
+		if err := seg.copyTo(dst); err != nil {
+			return w.dropped, err
+		}
+	}
+	return w.dropped, nil
+}
+
+func (s *rollingSegment) write(p []byte) error {
+	s.size += int64(len(p))
+	if s.file != nil {
+		_, err := s.file.Write(p)
+		return err
+	}
+	_, err := s.buf.Write(p)
+	return err
+}
+
+func (s *rollingSegment) copyTo(dst io.Writer) error {
+	if s.file == nil {
+		_, err := dst.Write(s.buf.Bytes())
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(dst, s.file)
+	return err
+}
+
+func (s *rollingSegment) discard() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+	s.buf = bytes.Buffer{}
+}
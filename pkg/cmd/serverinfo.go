@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ServerInfoEndpoint reports the Envoy build and its lifecycle state.
+const ServerInfoEndpoint = "/server_info"
+
+// envoyVersionFileName holds the version and state parsed from /server_info.
+const envoyVersionFileName = "envoy_version.txt"
+
+// writeEnvoyVersion parses the version and state from a /server_info body into
+// envoy_version.txt. Missing fields are written as "unknown"; a body that is
+// not JSON is an error, and the caller carries on without the file.
+func writeEnvoyVersion(data []byte, destDir string) (version, state string, err error) {
+	var info struct {
+		Version string `json:"version"`
+		State   string `json:"state"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", "", fmt.Errorf("parse %s: %w", ServerInfoEndpoint, err)
+	}
+	version, state = valueOr(info.Version, "unknown"), valueOr(info.State, "unknown")
+	content := fmt.Sprintf("version: %s\nstate: %s\n", version, state)
+	return version, state, os.WriteFile(filepath.Join(destDir, envoyVersionFileName), []byte(content), 0o644)
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionTrendsFileName is written to --output-dir, as .json and .txt, after a
+// run with more than one iteration.
+const sessionTrendsFileName = "session-trends"
+
+// HealthSummary holds the key health numbers of one snapshot, parsed from
+// /listeners, /clusters and /stats. Request counters are cumulative.
+type HealthSummary struct {
+	Listeners      int   `json:"listeners"`
+	Clusters       int   `json:"clusters"`
+	Hosts          int   `json:"hosts"`
+	UnhealthyHosts int   `json:"unhealthy_hosts"`
+	UpstreamRq     int64 `json:"upstream_rq_total"`
+	Upstream5xx    int64 `json:"upstream_rq_5xx"`
+	DownstreamRq   int64 `json:"downstream_rq_total"`
+	Downstream5xx  int64 `json:"downstream_rq_5xx"`
+}
+
+// healthSummary parses the captured endpoints in snapshotDir. It returns nil
+// when none of them was captured.
+func healthSummary(snapshotDir string) *HealthSummary {
+	var h HealthSummary
+	found := false
+	if data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/listeners"))); err == nil {
+		found = true
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.TrimSpace(line) != "" {
+				h.Listeners++
+			}
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/clusters"))); err == nil {
+		found = true
+		for _, cluster := range parseClustersText(string(data)) {
+			h.Clusters++
+			for _, host := range cluster.Endpoints {
+				h.Hosts++
+				if host.HealthFlags != "" && strings.ToLower(host.HealthFlags) != "healthy" {
+					h.UnhealthyHosts++
+				}
+			}
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/stats"))); err == nil {
+		found = true
+		for name, value := range parseStatsText(string(data)) {
+			switch {
+			case strings.HasPrefix(name, "cluster.") && strings.HasSuffix(name, ".upstream_rq_total"):
+				h.UpstreamRq += value
+			case strings.HasPrefix(name, "cluster.") && strings.HasSuffix(name, ".upstream_rq_5xx"):
+				h.Upstream5xx += value
+			case strings.HasPrefix(name, "http.") && strings.HasSuffix(name, ".downstream_rq_total"):
+				h.DownstreamRq += value
+			case strings.HasPrefix(name, "http.") && strings.HasSuffix(name, ".downstream_rq_5xx"):
+				h.Downstream5xx += value
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &h
+}
+
+// SessionTrends is session-trends.json: every pod's health across the
+// iterations of one run.
+type SessionTrends struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Pods        []PodTrend `json:"pods"`
+}
+
+// PodTrend is one pod's iterations, oldest first, and the direction each
+// metric moved between the first and last ("rising", "falling" or "steady").
+type PodTrend struct {
+	Pod        string            `json:"pod"`
+	Iterations []TrendPoint      `json:"iterations"`
+	Trends     map[string]string `json:"trends"`
+}
+
+// TrendPoint is one iteration of a pod. The error rates are the 5xx share of
+// the requests since the previous iteration (since startup for the first).
+type TrendPoint struct {
+	CapturedAt          time.Time     `json:"captured_at"`
+	Snapshot            string        `json:"snapshot,omitempty"`
+	Health              HealthSummary `json:"health"`
+	UpstreamErrorRate   float64       `json:"upstream_error_rate"`
+	DownstreamErrorRate float64       `json:"downstream_error_rate"`
+}
+
+// sessionRecorder collects each pod's HealthSummary across iterations.
+type sessionRecorder struct {
+	order  []string
+	points map[string][]TrendPoint
+}
+
+func newSessionRecorder() *sessionRecorder {
+	return &sessionRecorder{points: map[string][]TrendPoint{}}
+}
+
+func (s *sessionRecorder) add(target string, result *CaptureResult) {
+	if result.Health == nil {
+		return
+	}
+	point := TrendPoint{CapturedAt: result.StartedAt, Snapshot: result.TarPath, Health: *result.Health}
+	if point.Snapshot == "" {
+		point.Snapshot = result.SnapshotDir
+	}
+	prev := HealthSummary{}
+	if pts := s.points[target]; len(pts) > 0 {
+		prev = pts[len(pts)-1].Health
+	} else {
+		s.order = append(s.order, target)
+	}
+	point.UpstreamErrorRate = errorRate(point.Health.Upstream5xx-prev.Upstream5xx, point.Health.UpstreamRq-prev.UpstreamRq)
+	point.DownstreamErrorRate = errorRate(point.Health.Downstream5xx-prev.Downstream5xx, point.Health.DownstreamRq-prev.DownstreamRq)
+	s.points[target] = append(s.points[target], point)
+}
+
+// errorRate is errors/total, or 0 when there were no requests or the counters
+// went backwards (an Envoy restart).
+func errorRate(errors, total int64) float64 {
+	if total <= 0 || errors < 0 {
+		return 0
+	}
+	return float64(errors) / float64(total)
+}
+
+// write emits session-trends.json and .txt in outputDir when some pod was
+// captured more than once; it reports whether anything was written.
+func (s *sessionRecorder) write(outputDir string) (bool, error) {
+	trends := SessionTrends{GeneratedAt: time.Now()}
+	for _, target := range s.order {
+		pts := s.points[target]
+		if len(pts) < 2 {
+			continue
+		}
+		first, last := pts[0], pts[len(pts)-1]
+		trends.Pods = append(trends.Pods, PodTrend{
+			Pod:        target,
+			Iterations: pts,
+			Trends: map[string]string{
+				"listeners":             trendDirection(float64(first.Health.Listeners), float64(last.Health.Listeners)),
+				"clusters":              trendDirection(float64(first.Health.Clusters), float64(last.Health.Clusters)),
+				"unhealthy_hosts":       trendDirection(float64(first.Health.UnhealthyHosts), float64(last.Health.UnhealthyHosts)),
+				"upstream_error_rate":   trendDirection(first.UpstreamErrorRate, last.UpstreamErrorRate),
+				"downstream_error_rate": trendDirection(first.DownstreamErrorRate, last.DownstreamErrorRate),
+			},
+		})
+	}
+	if len(trends.Pods) == 0 {
+		return false, nil
+	}
+
+	if err := writeJSON(filepath.Join(outputDir, sessionTrendsFileName+".json"), trends); err != nil {
+		return false, err
+	}
+	f, err := os.Create(filepath.Join(outputDir, sessionTrendsFileName+".txt"))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	for _, pod := range trends.Pods {
+		fmt.Fprintf(f, "# %s: listeners %s, clusters %s, unhealthy hosts %s, upstream 5xx rate %s, downstream 5xx rate %s\n",
+			pod.Pod, pod.Trends["listeners"], pod.Trends["clusters"], pod.Trends["unhealthy_hosts"],
+			pod.Trends["upstream_error_rate"], pod.Trends["downstream_error_rate"])
+		w := tabwriter.NewWriter(f, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CAPTURED\tLISTENERS\tCLUSTERS\tUNHEALTHY/HOSTS\tUPSTREAM 5XX\tDOWNSTREAM 5XX")
+		for _, p := range pod.Iterations {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d/%d\t%.2f%%\t%.2f%%\n", p.CapturedAt.Format(time.RFC3339),
+				p.Health.Listeners, p.Health.Clusters, p.Health.UnhealthyHosts, p.Health.Hosts,
+				100*p.UpstreamErrorRate, 100*p.DownstreamErrorRate)
+		}
+		if err := w.Flush(); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(f)
+	}
+	return true, nil
+}
+
+func trendDirection(first, last float64) string {
+	switch {
+	case last > first:
+		return "rising"
+	case last < first:
+		return "falling"
+	}
+	return "steady"
+}
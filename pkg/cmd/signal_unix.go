@@ -0,0 +1,18 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyOnDemandSnapshot relays SIGUSR1, which requests an immediate snapshot.
+func notifyOnDemandSnapshot(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGUSR1)
+}
+
+func stopOnDemandSnapshot(ch chan<- os.Signal) {
+	signal.Stop(ch)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package cmd
+
+import "os"
+
+// notifyOnDemandSnapshot is a no-op on Windows, which has no SIGUSR1.
+func notifyOnDemandSnapshot(ch chan<- os.Signal) {}
+
+func stopOnDemandSnapshot(ch chan<- os.Signal) {}
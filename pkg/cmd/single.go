@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// singleArchiveName is the --single-archive tarball written to each snapshot directory.
+const singleArchiveName = "snapshot.tar.gz"
+
+func singleArchivePath(snapshotDir, prefix string) string {
+	return filepath.Join(snapshotDir, withOutputPrefix(prefix, singleArchiveName))
+}
+
+// CombinedManifest is the manifest.json at the root of a --single-archive
+// tarball. Each pod keeps its own manifest.json in its subdirectory.
+type CombinedManifest struct {
+	SchemaVersion int                   `json:"schema_version"`
+	Tool          ManifestTool          `json:"tool"`
+	IncidentID    string                `json:"incident_id,omitempty"`
+	CapturedAt    time.Time             `json:"captured_at"`
+	Pods          []CombinedManifestPod `json:"pods"`
+}
+
+// CombinedManifestPod is one pod of a --single-archive tarball.
+type CombinedManifestPod struct {
+	// Dir is the pod's subdirectory, <namespace>/<pod>.
+	Dir      string    `json:"dir"`
+	Outcome  string    `json:"outcome,omitempty"`
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// singleArchiveStage collects the pods of one --single-archive round under a
+// shared root. Pods may be added by concurrent captures; each is staged in its
+// own <namespace>/<pod> directory and the archive is assembled once at the end.
+type singleArchiveStage struct {
+	root string
+
+	mu       sync.Mutex
+	manifest CombinedManifest
+	results  []*CaptureResult
+}
+
+func newSingleArchiveStage(manifest CombinedManifest) (*singleArchiveStage, error) {
+	root, err := os.MkdirTemp("", "xdsnap-round-*")
+	if err != nil {
+		return nil, err
+	}
+	return &singleArchiveStage{root: root, manifest: manifest}, nil
+}
+
+// podDir is where a pod is staged.
+func (s *singleArchiveStage) podDir(target captureTarget) string {
+	return filepath.Join(s.root, target.Namespace, target.Pod)
+}
+
+// add records a captured pod for the combined manifest.
+func (s *singleArchiveStage) add(target captureTarget, result *CaptureResult) {
+	m, err := readStagedManifest(s.podDir(target))
+	if err != nil {
+		log.Printf("Failed to read staged manifest for pod %s: %v", target.key(), err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifest.Pods = append(s.manifest.Pods, CombinedManifestPod{Dir: target.key(), Outcome: result.Outcome, Manifest: m})
+	s.results = append(s.results, result)
+}
+
+// bundle archives every added pod into tarPath (see bundleSingleArchive) and
+// returns their results. It returns nil, nil when no pod was added.
+func (s *singleArchiveStage) bundle(tarPath string, opts archiveOptions, dryRun bool) ([]*CaptureResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.manifest.Pods) == 0 {
+		return nil, nil
+	}
+	sort.Slice(s.manifest.Pods, func(i, j int) bool { return s.manifest.Pods[i].Dir < s.manifest.Pods[j].Dir })
+	return s.results, bundleSingleArchive(s.root, tarPath, &s.manifest, opts, dryRun)
+}
+
+// readStagedManifest loads the manifest CaptureSnapshot wrote for a staged pod.
+func readStagedManifest(podDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(podDir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// bundleSingleArchive writes the combined manifest to stageRoot and archives
+// every staged pod into tarPath, or only lists the files when dryRun is set.
+func bundleSingleArchive(stageRoot, tarPath string, combined *CombinedManifest, opts archiveOptions, dryRun bool) error {
+	if err := writeJSON(filepath.Join(stageRoot, manifestFileName), combined); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %s would contain:\n", tarPath)
+		return listBundleFiles(os.Stdout, stageRoot)
+	}
+	if err := createTarGz(tarPath, stageRoot, opts); err != nil {
+		return fmt.Errorf("failed to create tar.gz file: %w", err)
+	}
+	fmt.Printf("Snapshot of %d pod(s) saved as %s\n", len(combined.Pods), tarPath)
+	return nil
+}
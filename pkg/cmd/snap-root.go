@@ -8,15 +8,39 @@ import (
 
 // NewRootCommand creates the root command for xDSnap
 func NewRootCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	var cpuProfile, traceFile string
+	stopProfiling := func() {}
 	rootCmd := &cobra.Command{
 		Use:   "xdsnap",
 		Short: "XDSnap captures Envoy state snapshots across Kubernetes pods for troubleshooting.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			stop, err := startProfiling(cpuProfile, traceFile)
+			if err != nil {
+				return err
+			}
+			stopProfiling = stop
+			return nil
+		},
 	}
+	// Profiles are written when the command returns, including with an error.
+	cobra.OnFinalize(func() { stopProfiling() })
+
+	// Hidden flags for profiling xdsnap itself (port-forward setup vs fetch vs bundling).
+	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpu-profile", "", "Write a Go CPU profile of xdsnap to this file")
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace", "", "Write a Go execution trace of xdsnap to this file")
+	_ = rootCmd.PersistentFlags().MarkHidden("cpu-profile")
+	_ = rootCmd.PersistentFlags().MarkHidden("trace")
 
 	// Add the capture subcommand
 	rootCmd.AddCommand(NewCaptureCommand(streams))
 	// Add the analyze subcommand
 	rootCmd.AddCommand(NewAnalyzeCommand(streams))
+	// Add the containers subcommand
+	rootCmd.AddCommand(NewContainersCommand(streams))
+	// Add the get subcommand
+	rootCmd.AddCommand(NewGetCommand(streams))
+	// Add the endpoints subcommand
+	rootCmd.AddCommand(NewEndpointsCommand(streams))
 
 	return rootCmd
 }
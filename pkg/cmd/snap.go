@@ -1,49 +1,309 @@
 package cmd
 
 import (
-	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/base64"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/markcampv/xDSnap/kube"
 )
 
 type SnapshotConfig struct {
-	PodName           string
-	ContainerName     string
-	Endpoints         []string
-	OutputDir         string
-	ExtraLogs         []string
-	Duration          time.Duration
-	EnableTrace       bool
-	TcpdumpEnabled    bool
-	SkipLogLevelReset bool
+	PodName        string
+	Namespace      string
+	ContainerName  string
+	Endpoints      []string
+	OutputDir      string
+	ExtraLogs      []string
+	Duration       time.Duration
+	EnableTrace    bool
+	TcpdumpEnabled bool
+	TcpdumpRotate  time.Duration
+	TcpdumpMode    string
+	// PcapSummary writes talkers, ports, resets, retransmits and TLS alerts
+	// from the captured pcaps to network/pcap-summary.txt.
+	PcapSummary bool
+	// RollingWindow, if set, keeps only the logs and rotated pcaps of the last
+	// window, bounding disk usage during long captures.
+	RollingWindow time.Duration
+	// PerFileCompression stores already-compressed artifacts (pcaps, .gz) without
+	// recompressing them when bundling.
+	PerFileCompression bool
+	SkipLogLevelReset  bool
+	OnComplete         string
+	// EphemeralDisabled skips every step that needs an ephemeral container:
+	// log-level changes, tcpdump, and the exec fallback for admin endpoints.
+	EphemeralDisabled bool
+	// Topology joins listeners, routes and clusters into topology.json.
+	Topology bool
+	// MaxSnapshotSize trims the largest artifacts until the archive fits (0 = unlimited).
+	MaxSnapshotSize int64
+	// CollectMetrics scrapes the sidecar's Prometheus port into metrics.prom;
+	// MetricsPort overrides detection from the pod spec.
+	CollectMetrics bool
+	MetricsPort    int
+	// GoroutineDump saves the sidecar's pprof goroutine dump to
+	// dataplane-goroutines.txt (port detected from the container spec).
+	GoroutineDump bool
+	// TraceMaxDuration resets the log level to info if trace logging has been on
+	// longer than this while the capture is still running (0 disables).
+	TraceMaxDuration time.Duration
+	// CompressConcurrency bundles with a parallel gzip writer when above 1.
+	CompressConcurrency int
+	// StatsUsedOnly adds "usedonly" to /stats requests so only stats that have
+	// been written to are captured.
+	StatsUsedOnly bool
+	// StreamConfigDump copies /config_dump from the port-forward straight to
+	// disk instead of buffering it in memory. The shape check is skipped.
+	StreamConfigDump bool
+	// CertChain verifies the certificate chains in /config_dump against
+	// CertChainCA (loaded from CertChainCAFile), or the dump's own trusted CAs
+	// when nil, and writes cert-chain-report.json. ExpectedSPIFFEID overrides
+	// matching the leaf against the bootstrap node's service.
+	CertChain        bool
+	CertChainCA      []*x509.Certificate
+	CertChainCAFile  string
+	ExpectedSPIFFEID string
+	// Expected is the --expect golden config_dump (read from ExpectedFile) the
+	// capture is diffed against into expected-diff.txt; more than
+	// ExpectedTolerance deviating fields sets CaptureResult.ConfigDriftExceeded.
+	Expected          any
+	ExpectedFile      string
+	ExpectedTolerance int
+	// NamespacedArchive names the archive <namespace>_<pod>_snapshot.tar.gz.
+	NamespacedArchive bool
+	// Mesh is MeshConsul or MeshNone and is recorded in the manifest.
+	Mesh string
+	// DrainTest gracefully drains the sidecar's listeners after everything else
+	// is captured and records /listeners and /stats before and after in drain/.
+	DrainTest bool
+	// Redact patterns are replaced with REDACTED in endpoint output before it
+	// is written.
+	Redact []*regexp.Regexp
+	// RedactSecrets replaces TLS private keys and inline secrets in /certs and
+	// /config_dump with REDACTED before they are written.
+	RedactSecrets bool
+	// DirectoryOnly writes the snapshot to <output-dir>/<pod>_snapshot/ and
+	// leaves it there unarchived; the path is returned as SnapshotDir.
+	DirectoryOnly bool
+	// StageDir, if set, receives the snapshot files in place of a temporary
+	// directory and nothing is bundled; the caller archives the staged pods
+	// together (--single-archive).
+	StageDir string
+	// DryRunTar lists what would be archived instead of writing the tarball;
+	// KeepTemp leaves the temporary snapshot directory in place for inspection.
+	DryRunTar bool
+	KeepTemp  bool
+	// AppendTar, if set, is an uncompressed tar that the snapshot is appended
+	// to under <snapshot dir>/<pod>_snapshot/ instead of writing a tarball.
+	AppendTar string
+	// PerContainerNetns records each container's network namespace view
+	// (addresses, routes, sockets, admin reachability) under network/netns/.
+	PerContainerNetns bool
+	// MTLSProbe is a host:port to run an openssl handshake against after the
+	// endpoints are captured; the output is saved to network/mtls-probe.txt.
+	MTLSProbe string
+	// Metadata holds the --annotate-from-annotation values recorded in manifest.json.
+	Metadata map[string]string
+	// KubeServerVersion and Flags are recorded in manifest.json.
+	KubeServerVersion string
+	Flags             map[string]string
+	// Consul, if set, reads the service's config entries into consul/.
+	Consul *ConsulConfigClient
+	// AdminPort is the Envoy admin port inside the pod (default 19000), used for
+	// endpoint fetches, the /logging POSTs and their reset.
+	AdminPort int
+	// WaitReady, if set, polls /ready for up to this long before capturing, so
+	// the snapshot reflects a settled proxy; WaitReadyLive also requires the
+	// /server_info state to be LIVE. The proxy is captured either way.
+	WaitReady     time.Duration
+	WaitReadyLive bool
+	// AdminPathPrefix is prepended to every admin request path, including
+	// /logging, for admin APIs served behind a path-routing proxy.
+	AdminPathPrefix string
+	// AdminUDS fetches admin endpoints over this Unix domain socket in the
+	// sidecar (via an ephemeral container) instead of the TCP admin port.
+	AdminUDS string
+	// RetryBudget caps the endpoint retries (port-forward retries and exec
+	// fallbacks) across the whole capture; 0 means unlimited.
+	RetryBudget int
+	// SkipPortForwardAfterFallback sends the remaining endpoint requests
+	// straight to the exec fallback once port-forward failed and it worked.
+	SkipPortForwardAfterFallback bool
+	// AdminURL sends every admin request, including /logging, to this base
+	// URL (e.g. an existing kubectl port-forward) instead of port-forwarding.
+	AdminURL string
+	// FailFast aborts the capture on the first endpoint or log stream failure
+	// instead of continuing with whatever could be collected.
+	FailFast bool
+	// EventsSince, if set, saves the pod's events last seen within this long
+	// before the capture started to k8s/events.json.
+	EventsSince time.Duration
+	// IncludeNodeInfo saves the pod's node object to k8s/node.json.
+	IncludeNodeInfo bool
+	// ResourceUsage saves metrics-server CPU/memory usage to k8s/resource-usage.json.
+	ResourceUsage bool
+	// OptionalEndpoints answering 404 are recorded as unavailable in the
+	// manifest instead of counting as failures.
+	OptionalEndpoints []string
+	// BufferLogsToDisk writes container logs to their files as they stream in
+	// instead of holding each log in memory until the stream ends.
+	BufferLogsToDisk bool
+	// EndpointsFirst fetches every admin endpoint before logs and tcpdump;
+	// otherwise only /config_dump is fetched early.
+	EndpointsFirst bool
+	// BundleJSON also writes every captured endpoint to endpoints.ndjson, one
+	// {endpoint, pod, ts, body} record per line, for log pipelines.
+	BundleJSON bool
+	// CaptureOrder, if set, is the full phase sequence from parseCaptureOrder
+	// and overrides EndpointsFirst.
+	CaptureOrder []string
+	// Baseline computes stats-rates.txt against an earlier snapshot's /stats.
+	Baseline *StatsBaseline
+	// OutputPrefix (e.g. an incident ID) prefixes the archive name and is
+	// recorded in the manifest.
+	OutputPrefix string
+	// Dedup, when set, replaces endpoint output unchanged since the previous
+	// iteration with a small pointer file.
+	Dedup *EndpointDedup
 }
 
-var DefaultEndpoints = []string{"/stats", "/config_dump", "/listeners", "/clusters", "/certs"}
+// CaptureResult describes a finished snapshot. Its fields are exposed to the
+// --on-complete hook template (e.g. {{.TarPath}}).
+type CaptureResult struct {
+	PodName       string
+	Namespace     string
+	ContainerName string
+	OutputDir     string
+	TarPath       string
+	// SnapshotDir is set instead of TarPath for --output-dir-only captures.
+	SnapshotDir string
+	StartedAt   time.Time
+	CompletedAt time.Time
+	KeyFindings []string
+	// Outcome classifies the capture's timing (see OutcomeCompleted); it is set
+	// by the caller, which knows the per-pod timeout and overall deadline.
+	Outcome string
+	// EndpointsCaptured and EndpointsFailed count admin endpoint fetches.
+	EndpointsCaptured int
+	EndpointsFailed   int
+	// LogStreamAttempts is how many tries opening each container's log stream took.
+	LogStreamAttempts map[string]int
+	// Health is parsed from /listeners, /clusters and /stats; repeat runs
+	// compare it across iterations in session-trends.json.
+	Health *HealthSummary
+	// ConfigDriftExceeded is set when the config_dump deviates from the
+	// --expect golden file by more than the tolerance, or could not be compared.
+	ConfigDriftExceeded bool
+	// Interrupted is set when the capture was cut short by cancellation.
+	Interrupted bool
+}
+
+// EndpointSuccessRatio is the share of admin endpoints captured successfully.
+func (r *CaptureResult) EndpointSuccessRatio() float64 {
+	total := r.EndpointsCaptured + r.EndpointsFailed
+	if total == 0 {
+		return 1
+	}
+	return float64(r.EndpointsCaptured) / float64(total)
+}
+
+// Tcpdump retrieval modes: stream base64 through the ephemeral container's logs,
+// or write the pcap to a file in the container and copy it out over exec.
+const (
+	TcpdumpModeLogs = "logs"
+	TcpdumpModeFile = "file"
+)
+
+// Meshes for --mesh: Consul sidecars and gateways, or a standalone Envoy that
+// is captured from the named container without mesh discovery.
+const (
+	MeshConsul = "consul"
+	MeshNone   = "none"
+)
+
+var DefaultEndpoints = []string{"/stats", "/config_dump", "/listeners", "/clusters", "/certs", ServerInfoEndpoint}
 
-func CaptureSnapshot(kubeService kube.KubernetesApiService, config SnapshotConfig) error {
+// RecentLookupsEndpoint lists recently created stat names. It is not captured by
+// default and only returns data when lookup tracking is enabled in Envoy.
+const RecentLookupsEndpoint = "/stats/recentlookups"
+
+// StatsPrometheusEndpoint serves the stats in the Prometheus text exposition
+// format, for tooling that can't ingest /stats. It is not captured by default.
+const StatsPrometheusEndpoint = "/stats/prometheus"
+
+// InitDumpEndpoint lists the init targets Envoy is still waiting on before it
+// becomes ready. Envoy versions before 1.18 do not have it.
+const InitDumpEndpoint = "/init_dump"
+
+// endpointFileNames overrides the default "<endpoint>.json" file name for admin
+// endpoints that return plain text.
+var endpointFileNames = map[string]string{
+	RecentLookupsEndpoint: "recentlookups.txt",
+	XDSStatsEndpoint:      "xds-stats.txt",
+}
+
+// CaptureSnapshot captures one pod. When ctx is cancelled (e.g. on Ctrl-C) the
+// remaining phases and in-pod extras are skipped, and what was captured is
+// still bundled and the Envoy log level reset.
+func CaptureSnapshot(ctx context.Context, kubeService kube.KubernetesApiService, config SnapshotConfig) (*CaptureResult, error) {
 	if len(config.Endpoints) == 0 {
 		config.Endpoints = DefaultEndpoints
 	}
 
+	result := &CaptureResult{
+		PodName:       config.PodName,
+		Namespace:     config.Namespace,
+		ContainerName: config.ContainerName,
+		OutputDir:     config.OutputDir,
+		StartedAt:     time.Now(),
+	}
+	manifest := newManifest(config, result)
+	if config.DirectoryOnly && config.StageDir == "" {
+		config.StageDir = filepath.Join(config.OutputDir, strings.TrimSuffix(withOutputPrefix(config.OutputPrefix, podArchiveName(config)), ".tar.gz"))
+	}
+
 	log.Printf("CaptureSnapshot called with Pod=%s Container=%s EnableTrace=%v", config.PodName, config.ContainerName, config.EnableTrace)
 
-	tempDir, err := os.MkdirTemp("", config.PodName)
-	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
+	// aborted keeps the temporary directory of a capture stopped by
+	// --fail-fast, for inspection.
+	aborted := false
+	tempDir := config.StageDir
+	if tempDir != "" {
+		// Start clean: a --pod-retries re-run reuses the staged directory.
+		if err := os.RemoveAll(tempDir); err != nil {
+			return nil, fmt.Errorf("failed to clear staging directory: %w", err)
+		}
+		if err := os.MkdirAll(tempDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create staging directory: %w", err)
+		}
+	} else {
+		var err error
+		// The random suffix keeps concurrent captures of same-named pods apart.
+		tempDir, err = os.MkdirTemp("", "xdsnap-"+config.PodName+"-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer func() {
+			if config.KeepTemp || aborted {
+				log.Printf("Kept temporary directory for pod %s: %s", config.PodName, tempDir)
+				return
+			}
+			os.RemoveAll(tempDir)
+		}()
 	}
-	defer os.RemoveAll(tempDir)
 
 	// Capture pod metadata for downstream analysis/graphing
 	if podJSON, err := kubeService.GetPodJSON(config.PodName); err != nil {
@@ -53,236 +313,829 @@ func CaptureSnapshot(kubeService kube.KubernetesApiService, config SnapshotConfi
 		if err := os.WriteFile(metaPath, podJSON, 0o644); err != nil {
 			log.Printf("Failed to write pod metadata for %s: %v", config.PodName, err)
 		}
+		if identity, err := writePodIdentity(podJSON, tempDir); err != nil {
+			log.Printf("Failed to extract identity for pod %s: %v", config.PodName, err)
+		} else {
+			manifest.Summary.Identity = identity
+		}
+		if err := captureInjectInit(kubeService, config.PodName, podJSON, tempDir); err != nil {
+			log.Printf("Failed to capture connect-inject init container for %s: %v", config.PodName, err)
+		}
+		if config.IncludeNodeInfo {
+			if err := captureNode(kubeService, podJSON, tempDir); err != nil {
+				log.Printf("Failed to capture node for pod %s: %v", config.PodName, err)
+			}
+		}
 	}
 
-	// Stream logs from app container + any extras (e.g., envoy-sidecar / consul-dataplane)
-	logResults := make(chan struct{}, len(config.ExtraLogs)+1)
-	for _, c := range append([]string{config.ContainerName}, config.ExtraLogs...) {
-		if c == "" {
-			logResults <- struct{}{}
-			continue
+	if config.ResourceUsage {
+		if err := captureResourceUsage(kubeService, config.PodName, tempDir); err != nil {
+			log.Printf("Failed to capture resource usage for pod %s: %v", config.PodName, err)
 		}
-		c := c
-		go func() {
-			log.Printf("Starting log stream for container %s", c)
-			logBytes, err := streamLogsWithTimeout(kubeService, config.PodName, c, config.Duration+10*time.Second)
-			if err != nil {
-				log.Printf("Failed to stream logs for container %s: %v", c, err)
-			} else {
-				logsPath := filepath.Join(tempDir, fmt.Sprintf("%s-logs.txt", c))
-				if err := os.WriteFile(logsPath, logBytes, 0o644); err != nil {
-					log.Printf("Failed to write logs for container %s: %v", c, err)
+	}
+
+	if err := captureNetworkPolicies(kubeService, config.PodName, tempDir); err != nil {
+		log.Printf("Failed to capture network policies for pod %s: %v", config.PodName, err)
+	}
+
+	if config.EventsSince > 0 {
+		if err := capturePodEvents(kubeService, config.PodName, result.StartedAt.Add(-config.EventsSince), tempDir); err != nil {
+			log.Printf("Failed to capture events for pod %s: %v", config.PodName, err)
+		}
+	}
+
+	tarFilePath := filepath.Join(config.OutputDir, withOutputPrefix(config.OutputPrefix, podArchiveName(config)))
+	if config.DirectoryOnly {
+		tarFilePath = config.StageDir
+	} else if config.StageDir != "" {
+		tarFilePath = singleArchivePath(config.OutputDir, config.OutputPrefix)
+	} else if config.AppendTar != "" {
+		tarFilePath = config.AppendTar
+	}
+
+	// --- Envoy admin endpoints via PORT-FORWARD (with exec fallback inside EnvoyAdminClient.Get) ---
+	admin := newSnapshotAdminClient(kubeService, config)
+	// fetchAndWriteEndpoint only returns an error for fetch failures, which abort
+	// the capture under --fail-fast.
+	fetchAndWriteEndpoint := func(endpoint string) error {
+		if config.StreamConfigDump && endpoint == "/config_dump" && config.Dedup == nil && config.AdminUDS == "" && len(config.Redact) == 0 && !config.RedactSecrets {
+			filePath := filepath.Join(tempDir, filepath.FromSlash(endpointFileName(endpoint)))
+			n, err := admin.Stream(endpoint, filePath)
+			if err == nil {
+				fmt.Printf("Streamed %s for %s (%d bytes) to %s\n", endpoint, config.PodName, n, filePath)
+				return nil
+			}
+			log.Printf("Streaming %s failed, retrying buffered: %v", endpoint, err)
+		}
+
+		requestPath := endpoint
+		if config.StatsUsedOnly {
+			requestPath = withStatsUsedOnly(endpoint)
+		}
+		data, err := admin.Get(requestPath)
+		var statusErr *kube.HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound && containsString(config.OptionalEndpoints, endpoint) {
+			log.Printf("Optional endpoint %s is not available on pod %s", endpoint, config.PodName)
+			manifest.Unavailable = append(manifest.Unavailable, endpoint)
+			return nil
+		}
+		if err != nil {
+			log.Printf("Error capturing %s: %v", endpoint, err)
+			return fmt.Errorf("capture %s: %w", endpoint, err)
+		}
+		if len(data) == 0 {
+			log.Printf("Warning: No data received from endpoint %s for pod %s", endpoint, config.PodName)
+			return fmt.Errorf("capture %s: no data received", endpoint)
+		}
+		if config.RedactSecrets && isSecretEndpoint(endpoint) {
+			var n int
+			if data, n = redactSecrets(data); n > 0 {
+				log.Printf("Redacted %d secret value(s) in %s for pod %s", n, endpoint, config.PodName)
+			}
+		}
+		data = redact(data, config.Redact)
+		if config.Dedup != nil {
+			archive := filepath.Join(filepath.Base(config.OutputDir), filepath.Base(tarFilePath))
+			if ref := config.Dedup.Check(config.Namespace+"/"+config.PodName, endpoint, data, archive, result.StartedAt); ref != nil {
+				refPath := filepath.Join(tempDir, endpointFileName(endpoint)+dedupRefSuffix)
+				if err := writeJSON(refPath, ref); err != nil {
+					log.Printf("Failed to write dedup pointer for %s: %v", endpoint, err)
+				} else {
+					fmt.Printf("Unchanged %s for %s; pointer saved to %s\n", endpoint, config.PodName, refPath)
 				}
+				return nil
 			}
-			logResults <- struct{}{}
-		}()
+		}
+		filePath := filepath.Join(tempDir, filepath.FromSlash(endpointFileName(endpoint)))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			log.Printf("Failed to create directory for %s: %v", endpoint, err)
+			return nil
+		}
+		if err := os.WriteFile(filePath, data, 0o644); err != nil {
+			log.Panicf("Failed to write data for %s: %v", endpoint, err)
+		} else {
+			fmt.Printf("Captured %s for %s and saved to %s\n", endpoint, config.PodName, filePath)
+		}
+
+		if endpoint == "/stats" {
+			manifest.StatsCapturedAt = time.Now()
+			if config.Baseline != nil {
+				ratesPath := filepath.Join(tempDir, statsRatesFileName)
+				if err := writeStatsRates(ratesPath, config.Baseline, parseStatsText(string(data)), manifest.StatsCapturedAt); err != nil {
+					log.Printf("Failed to compute stats rates for pod %s: %v", config.PodName, err)
+				}
+			}
+		}
+
+		if endpoint == ServerInfoEndpoint {
+			if version, state, err := writeEnvoyVersion(data, tempDir); err != nil {
+				log.Printf("Failed to read the Envoy version of pod %s: %v", config.PodName, err)
+			} else {
+				fmt.Printf("Envoy on %s: version=%s state=%s\n", config.PodName, version, state)
+			}
+		}
+
+		if endpoint == InitDumpEndpoint {
+			if targets, err := unreadyInitTargets(data); err != nil {
+				log.Printf("Failed to parse %s for pod %s: %v", endpoint, config.PodName, err)
+			} else if len(targets) > 0 {
+				log.Printf("Pod %s is waiting on %d init target(s): %s", config.PodName, len(targets), strings.Join(targets, ", "))
+			}
+		}
+
+		if endpoint == XDSStatsEndpoint {
+			xds := parseXDSStats(string(data))
+			manifest.Summary.XDS = xds
+			connected := "unknown"
+			if xds.ConnectedState != nil {
+				connected = fmt.Sprint(*xds.ConnectedState == 1)
+			}
+			fmt.Printf("xDS for %s: connected=%s update_success=%d update_rejected=%d update_failure=%d\n",
+				config.PodName, connected, xds.UpdateSuccess, xds.UpdateRejected, xds.UpdateFailure)
+		}
+		return nil
+	}
+
+	// captured records each fetched endpoint for --bundle-json.
+	var captured []capturedEndpoint
+	captureEndpoint := func(endpoint string) error {
+		err := fetchAndWriteEndpoint(endpoint)
+		if err != nil {
+			result.EndpointsFailed++
+		} else {
+			result.EndpointsCaptured++
+			captured = append(captured, capturedEndpoint{Endpoint: endpoint, At: time.Now()})
+		}
+		return err
+	}
+
+	// Phases run in captureOrder: by default /config_dump is fetched before
+	// logs and tcpdump so that an interrupted capture still holds the most
+	// valuable artifacts, and --capture-order lets users pick their own.
+	phaseEndpoints := groupEndpointsByPhase(config.Endpoints)
+
+	type logResult struct {
+		container string
+		attempts  int
+		err       error
+	}
+	var logResults chan logResult
+	// captureCtx stops the log streams when the capture is aborted.
+	captureCtx, cancelCapture := context.WithCancel(ctx)
+	defer cancelCapture()
+	// waitLogs waits for the log streams to finish flushing and returns the
+	// first failure. Later calls return nil.
+	waitLogs := func() error {
+		if result.LogStreamAttempts != nil {
+			return nil
+		}
+		var logErr error
+		result.LogStreamAttempts = map[string]int{}
+		for i := 0; i < cap(logResults); i++ {
+			r := <-logResults
+			if r.container != "" {
+				result.LogStreamAttempts[r.container] = r.attempts
+			}
+			if r.err != nil && logErr == nil {
+				logErr = r.err
+			}
+		}
+		return logErr
+	}
+	// levelRaised is set once the Envoy log level has been changed, so that
+	// failFast knows to restore it.
+	levelRaised := false
+	var traceTimer *time.Timer
+	defer func() {
+		if traceTimer != nil {
+			traceTimer.Stop()
+		}
+	}()
+
+	// failFast aborts the capture under --fail-fast: the log streams are
+	// stopped and drained, ephemeral containers left running are stopped and
+	// the log level is restored. The temporary directory is kept.
+	failFast := func(err error) error {
+		cancelCapture()
+		if logErr := waitLogs(); logErr != nil {
+			log.Printf("Log streams of pod %s stopped: %v", config.PodName, logErr)
+		}
+		if cerr := kubeService.CleanupEphemeral(); cerr != nil {
+			log.Printf("Failed to clean up ephemeral containers: %v", cerr)
+		}
+		aborted = true
+		if levelRaised {
+			if rerr := admin.SetLogLevel("info"); rerr != nil {
+				log.Printf("Failed to reset log level to info: %v", rerr)
+			}
+		}
+		return err
 	}
 
-	// --- Set Envoy log level via EPHEMERAL container (no docker.sock) ---
-	logLevel := "debug"
-	if config.EnableTrace {
-		logLevel = "trace"
+	startLogs := func() {
+		// Stream logs from app container + any extras (e.g., envoy-sidecar / consul-dataplane)
+		logResults = make(chan logResult, len(config.ExtraLogs)+1)
+		for _, c := range append([]string{config.ContainerName}, config.ExtraLogs...) {
+			if c == "" {
+				logResults <- logResult{}
+				continue
+			}
+			c := c
+			go func() {
+				log.Printf("Starting log stream for container %s", c)
+				logsPath := filepath.Join(tempDir, fmt.Sprintf("%s-logs.txt", c))
+				attempts, err := streamLogsToFile(captureCtx, kubeService, config.PodName, c, config.Duration+10*time.Second, config.RollingWindow, logsPath, config.BufferLogsToDisk)
+				if err != nil {
+					log.Printf("Failed to stream logs for container %s: %v", c, err)
+					logResults <- logResult{c, attempts, fmt.Errorf("stream logs for container %s: %w", c, err)}
+					return
+				}
+				logResults <- logResult{c, attempts, nil}
+			}()
+		}
+
+		// --- Set Envoy log level via EPHEMERAL container (no docker.sock) ---
+		if config.EphemeralDisabled {
+			return
+		}
+		logLevel := "debug"
+		if config.EnableTrace {
+			logLevel = "trace"
+			log.Printf("Warning: enabling Envoy trace logging on pod %s; trace output can overwhelm logging on busy proxies", config.PodName)
+		}
+		log.Printf("Setting Envoy log level to '%s' via ephemeral container", logLevel)
+		if err := admin.SetLogLevel(logLevel); err != nil {
+			log.Printf("Failed to set log level: %v", err)
+		}
+		levelRaised = true
+
+		// Safety timer: force the level back to info if a trace capture overruns.
+		if config.EnableTrace && config.TraceMaxDuration > 0 {
+			traceTimer = time.AfterFunc(config.TraceMaxDuration, func() {
+				log.Printf("Trace logging on pod %s exceeded %s; resetting Envoy log level to 'info' while capture continues", config.PodName, config.TraceMaxDuration)
+				if err := admin.SetLogLevel("info"); err != nil {
+					log.Printf("Failed to reset log level after trace timeout: %v", err)
+				}
+			})
+		}
 	}
-	log.Printf("Setting Envoy log level to '%s' via ephemeral container", logLevel)
-	curlURL := fmt.Sprintf("http://127.0.0.1:19000/logging?level=%s", logLevel)
-	if err := kubeService.RunEphemeralInTargetNetNS(
-		config.PodName,
-		config.ContainerName, // any container in the pod shares the netns
-		[]string{"sh", "-c", "curl -s -X POST " + curlURL},
-		false,
-		30*time.Second,
-	); err != nil {
-		log.Printf("Failed to set log level: %v", err)
+
+	// probeNetns runs the --per-container-netns probe once, for both the
+	// tcpdump phase and the admin fetches after the capture, and returns the
+	// containers in a network namespace other than the sidecar's.
+	var netnsProbed bool
+	var foreignNetns []string
+	probeNetns := func() []string {
+		if netnsProbed {
+			return foreignNetns
+		}
+		netnsProbed = true
+		var err error
+		if foreignNetns, err = probeContainerNetns(kubeService, config, tempDir); err != nil {
+			log.Printf("Failed to record per-container netns views for pod %s: %v", config.PodName, err)
+		} else if len(foreignNetns) > 0 {
+			log.Printf("Containers of pod %s outside the sidecar's network namespace: %s", config.PodName, strings.Join(foreignNetns, ", "))
+		}
+		return foreignNetns
 	}
 
-	// --- Optional tcpdump capture (runtime-agnostic; streams base64 via logs) ---
-	if config.TcpdumpEnabled {
+	runTcpdump := func() {
+		if !config.TcpdumpEnabled {
+			return
+		}
+		if config.EphemeralDisabled {
+			log.Printf("Skipping tcpdump for pod %s: ephemeral containers are unavailable", config.PodName)
+			return
+		}
+
+		// --- Optional file-based tcpdump capture (pcaps copied out of the pod over exec) ---
+		if config.TcpdumpRotate > 0 || config.TcpdumpMode == TcpdumpModeFile {
+			destDir := tempDir
+			if config.TcpdumpRotate > 0 {
+				log.Printf("Starting tcpdump via ephemeral container (rotating every %s)...", config.TcpdumpRotate)
+				destDir = filepath.Join(tempDir, "network")
+			} else {
+				log.Printf("Starting tcpdump via ephemeral container (copying pcap file)...")
+			}
+			if config.PerContainerNetns {
+				log.Printf("Per-container netns tcpdump is only taken when tcpdump streams to logs; capturing the sidecar's namespace only")
+			}
+			pcaps, err := captureTcpdumpFiles(kubeService, config, destDir)
+			if err != nil {
+				log.Printf("Failed to capture tcpdump: %v", err)
+			} else {
+				log.Printf("Saved %d .pcap file(s)", len(pcaps))
+				manifest.Pcaps = append(manifest.Pcaps, pcapSizes(tempDir, pcaps)...)
+			}
+			return
+		}
+
+		// --- Optional tcpdump capture (runtime-agnostic; streams base64 via logs) ---
 		log.Printf("Starting tcpdump via ephemeral container (streaming to logs)...")
+		var foreign []string
+		if config.PerContainerNetns {
+			foreign = probeNetns()
+		}
+		// Containers in a namespace of their own are captured alongside the
+		// sidecar, into network/netns/<container>.pcap.
+		foreignNames := make([]string, len(foreign))
+		foreignErrs := make([]error, len(foreign))
+		var wg sync.WaitGroup
+		for i, c := range foreign {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				foreignNames[i], foreignErrs[i] = kubeService.StartEphemeralTcpdumpInContainerNetns(config.PodName, c, config.Duration)
+			}()
+		}
 		ephemName, err := kubeService.CreateConcurrentTcpdumpCapturePod(
 			config.PodName,
 			[]string{config.ContainerName, "envoy-sidecar", "consul-dataplane"},
 			config.Duration,
 		)
+		wg.Wait()
+		var streams []tcpdumpStream
 		if err != nil {
 			log.Printf("Failed to start tcpdump: %v", err)
 		} else {
-			var logsBuf bytes.Buffer
-			if err := kubeService.FetchContainerLogs(context.Background(), config.PodName, ephemName, false, &logsBuf); err != nil {
-				log.Printf("Failed to fetch tcpdump logs for %s: %v", ephemName, err)
-			} else if logsBuf.Len() == 0 {
-				log.Printf("No tcpdump data found in logs for %s", ephemName)
+			streams = append(streams, tcpdumpStream{Container: ephemName, File: "xdsnap.pcap"})
+		}
+		for i, c := range foreign {
+			if foreignErrs[i] != nil {
+				log.Printf("Failed to start tcpdump in the network namespace of container %s: %v", c, foreignErrs[i])
+				continue
+			}
+			streams = append(streams, tcpdumpStream{Container: foreignNames[i], File: netnsPcapFile(c)})
+		}
+		if len(streams) == 0 {
+			return
+		}
+		pcaps := decodeTcpdumpStreams(kubeService, config.PodName, tempDir, streams)
+		for _, p := range pcaps {
+			if p.Error != "" {
+				log.Printf("Failed to save tcpdump capture: %s", p.Error)
 			} else {
-				raw := logsBuf.String()
-				clean := regexp.MustCompile(`[^A-Za-z0-9+/=]`).ReplaceAllString(strings.TrimSpace(raw), "")
-				if clean == "" {
-					log.Printf("No base64 tcpdump data after sanitization")
-				} else {
-					data, decErr := base64.StdEncoding.DecodeString(clean)
-					if decErr != nil {
-						log.Printf("Failed to decode base64 tcpdump stream (raw=%dB, clean=%dB): %v", len(raw), len(clean), decErr)
-					} else {
-						pcapPath := filepath.Join(tempDir, "xdsnap.pcap")
-						if werr := os.WriteFile(pcapPath, data, 0o644); werr != nil {
-							log.Printf("Failed to write pcap file: %v", werr)
-						} else {
-							log.Printf("Saved .pcap file: %s", pcapPath)
-						}
-					}
-				}
+				log.Printf("Saved .pcap file: %s (%d bytes)", filepath.Join(tempDir, p.File), p.Bytes)
 			}
 		}
+		manifest.Pcaps = append(manifest.Pcaps, pcaps...)
 	}
 
-	// --- Envoy admin endpoints via PORT-FORWARD (with exec fallback inside fetchEnvoyEndpoint) ---
-	for _, endpoint := range config.Endpoints {
-		data, err := fetchEnvoyEndpoint(kubeService, config.PodName, config.ContainerName, endpoint)
-		if err != nil {
-			log.Printf("Error capturing %s: %v", endpoint, err)
-			continue
+	if config.WaitReady > 0 {
+		// Probe over the port-forward or --admin-url only: an exec fallback
+		// would add an ephemeral container to the pod on every poll.
+		probe := newSnapshotAdminClient(kubeService, config)
+		probe.Retries = 1
+		probe.Budget = nil
+		probe.ExecFallback = config.AdminUDS != "" && !config.EphemeralDisabled
+		log.Printf("Waiting up to %s for Envoy on pod %s to report ready", config.WaitReady, config.PodName)
+		readiness := waitEnvoyReady(ctx, probe, config.WaitReady, config.WaitReadyLive)
+		if readiness.Ready {
+			log.Printf("Envoy on pod %s ready after %.1fs", config.PodName, readiness.WaitedSeconds)
+		} else {
+			log.Printf("Warning: Envoy on pod %s not ready after %s, capturing anyway: %s", config.PodName, config.WaitReady, readiness.Error)
 		}
-		if len(data) == 0 {
-			log.Printf("Warning: No data received from endpoint %s for pod %s", endpoint, config.PodName)
-			continue
+		manifest.Readiness = readiness
+	}
+
+phases:
+	for _, phase := range captureOrder(config) {
+		if ctx.Err() != nil {
+			break
 		}
-		filePath := filepath.Join(tempDir, fmt.Sprintf("%s.json", strings.TrimPrefix(endpoint, "/")))
-		if err := os.WriteFile(filePath, data, 0o644); err != nil {
-			log.Panicf("Failed to write data for %s: %v", endpoint, err)
+		switch phase {
+		case PhaseLogs:
+			startLogs()
+		case PhaseTcpdump:
+			runTcpdump()
+		default:
+			for _, endpoint := range phaseEndpoints[phase] {
+				if ctx.Err() != nil {
+					break phases
+				}
+				if err := captureEndpoint(endpoint); err != nil && config.FailFast {
+					return nil, failFast(err)
+				}
+			}
+		}
+	}
+	// Once interrupted, nothing more is started in the pod.
+	result.Interrupted = ctx.Err() != nil
+	if result.Interrupted {
+		log.Printf("Capture of pod %s interrupted; saving what was captured", config.PodName)
+		manifest.Interrupted = true
+	}
+
+	if config.PcapSummary && len(manifest.Pcaps) > 0 {
+		if err := writePcapSummary(tempDir, manifest.Pcaps); err != nil {
+			log.Printf("Failed to summarize pcaps for pod %s: %v", config.PodName, err)
 		} else {
-			fmt.Printf("Captured %s for %s and saved to %s\n", endpoint, config.PodName, filePath)
+			log.Printf("Wrote pcap summary for pod %s to %s", config.PodName, pcapSummaryFileName)
 		}
 	}
 
-	// Wait for all log streams to finish flushing
-	for i := 0; i < cap(logResults); i++ {
-		<-logResults
+	if config.CollectMetrics && !result.Interrupted {
+		if err := captureMetrics(kubeService, config, tempDir); err != nil {
+			log.Printf("Failed to capture metrics for pod %s: %v", config.PodName, err)
+		}
 	}
 
-	// Bundle snapshot
-	tarFilePath := filepath.Join(config.OutputDir, fmt.Sprintf("%s_snapshot.tar.gz", config.PodName))
-	if err := createTarGz(tarFilePath, tempDir); err != nil {
-		return fmt.Errorf("failed to create tar.gz file: %w", err)
+	if config.GoroutineDump && !result.Interrupted {
+		if err := captureGoroutineDump(kubeService, config, tempDir); err != nil {
+			log.Printf("Failed to capture goroutine dump for pod %s: %v", config.PodName, err)
+		}
 	}
-	fmt.Printf("Snapshot for %s saved as %s\n", config.PodName, tarFilePath)
 
-	// Reset log level via EPHEMERAL container
-	if !config.SkipLogLevelReset {
-		resetURL := "http://127.0.0.1:19000/logging?level=info"
-		log.Printf("Resetting Envoy log level back to 'info' on pod: %s", config.PodName)
-		if err := kubeService.RunEphemeralInTargetNetNS(
-			config.PodName,
-			config.ContainerName,
-			[]string{"sh", "-c", "curl -s -X POST " + resetURL},
-			false,
-			30*time.Second,
-		); err != nil {
-			log.Printf("Failed to reset log level to info: %v", err)
+	if config.PerContainerNetns && !result.Interrupted {
+		if config.EphemeralDisabled {
+			log.Printf("Skipping per-container netns views for pod %s: ephemeral containers are unavailable", config.PodName)
+		} else {
+			for _, container := range probeNetns() {
+				if err := captureNetnsAdmin(kubeService, config, container, tempDir); err != nil {
+					log.Printf("Failed to fetch admin endpoints in the network namespace of container %s: %v", container, err)
+				}
+			}
 		}
 	}
 
-	return nil
-}
+	if config.MTLSProbe != "" && !result.Interrupted {
+		if config.EphemeralDisabled {
+			log.Printf("Skipping mTLS probe for pod %s: ephemeral containers are unavailable", config.PodName)
+		} else if err := probeMTLS(kubeService, config, config.MTLSProbe, tempDir); err != nil {
+			log.Printf("mTLS probe from pod %s to %s failed: %v", config.PodName, config.MTLSProbe, err)
+		}
+	}
 
-func streamLogsWithTimeout(kubeService kube.KubernetesApiService, pod, container string, duration time.Duration) ([]byte, error) {
-	var logsBuf bytes.Buffer
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
-	defer cancel()
+	node, err := writeNodeInfo(tempDir)
+	if err != nil {
+		log.Printf("Failed to extract node info for pod %s: %v", config.PodName, err)
+	} else if node != nil {
+		manifest.Summary.Locality = node.Locality
+	}
 
-	done := make(chan error, 1)
-	go func() {
-		done <- kubeService.FetchContainerLogs(ctx, pod, container, true, &logsBuf)
-	}()
+	if config.Consul != nil {
+		if services := consulServiceNames(tempDir, node); len(services) == 0 {
+			log.Printf("Skipping Consul config entries for pod %s: no Consul service name found", config.PodName)
+		} else if err := captureConsulConfigEntries(config.Consul, services, tempDir); err != nil {
+			log.Printf("Failed to capture Consul config entries for pod %s: %v", config.PodName, err)
+		}
+	}
 
-	select {
-	case <-ctx.Done():
-		return logsBuf.Bytes(), nil
-	case err := <-done:
-		return logsBuf.Bytes(), err
+	if _, err := writeObservability(tempDir); err != nil {
+		log.Printf("Failed to extract telemetry config for pod %s: %v", config.PodName, err)
+	}
+
+	if counts, err := configCounts(tempDir); err != nil {
+		log.Printf("Failed to count config_dump resources for pod %s: %v", config.PodName, err)
+	} else if counts != nil {
+		manifest.Summary.ConfigCounts = counts
 	}
-}
 
-func fetchEnvoyEndpoint(kubeService kube.KubernetesApiService, pod, container, endpoint string) ([]byte, error) {
-	const podPort = 19000
-	const maxRetries = 5
-	const retryDelay = 2 * time.Second
+	if warming, err := warmingState(tempDir); err != nil {
+		log.Printf("Failed to check warming state for pod %s: %v", config.PodName, err)
+	} else if warming != nil {
+		log.Printf("Warning: pod %s has %d warming cluster(s) and %d warming listener(s) (%d not yet active)",
+			config.PodName, len(warming.Clusters), len(warming.Listeners), len(warming.NotActive))
+		manifest.Summary.Warming = warming
+	}
 
-	// First attempt: port-forward
-	for i := 0; i < maxRetries; i++ {
-		b, err := kubeService.PortForwardGET(pod, podPort, endpoint)
-		if err == nil && len(b) > 0 {
-			return b, nil
+	if config.CertChain {
+		if _, err := writeCertChainReport(tempDir, config.CertChainCA, config.CertChainCAFile, config.ExpectedSPIFFEID); err != nil {
+			log.Printf("Failed to build certificate chain report for pod %s: %v", config.PodName, err)
 		}
-		time.Sleep(retryDelay)
 	}
 
-	// Fallback: ephemeral curl inside pod netns
-	var buf bytes.Buffer
-	curlCmd := []string{
-		"sh", "-c",
-		fmt.Sprintf("curl -s http://127.0.0.1:%d%s", podPort, endpoint),
+	if config.Expected != nil {
+		drift, err := writeExpectedDiff(tempDir, config.Expected, config.ExpectedFile, config.ExpectedTolerance)
+		if err != nil {
+			log.Printf("Failed to compare pod %s against %s: %v", config.PodName, config.ExpectedFile, err)
+			result.ConfigDriftExceeded = true
+		} else {
+			if drift.Total() > 0 {
+				log.Printf("Pod %s deviates from %s in %d field(s) (tolerance %d), see %s",
+					config.PodName, config.ExpectedFile, drift.Total(), drift.Tolerance, expectedDiffFileName)
+			}
+			result.ConfigDriftExceeded = drift.Exceeded()
+			manifest.Summary.ExpectedDrift = drift
+		}
 	}
 
-	err := kubeService.RunEphemeralInTargetNetNSWithOutput(
-		pod,
-		container,
-		curlCmd,
-		false,
-		15*time.Second,
-		&buf,
-		nil,
-	)
-	if err == nil && buf.Len() > 0 {
-		log.Printf("Fetched %s from pod %s via ephemeral curl", endpoint, pod)
-		return buf.Bytes(), nil
+	if config.Topology {
+		if err := writeTopology(tempDir); err != nil {
+			log.Printf("Failed to build topology for pod %s: %v", config.PodName, err)
+		}
 	}
 
-	return nil, fmt.Errorf("port-forward and ephemeral curl both failed for %s", endpoint)
-}
+	// Last, since it stops the listeners; the log streams still record the drain.
+	if config.DrainTest && !result.Interrupted {
+		if config.EphemeralDisabled {
+			log.Printf("Skipping --drain-test for pod %s: it requires ephemeral containers", config.PodName)
+		} else if err := runDrainTest(kubeService, config, tempDir); err != nil {
+			log.Printf("Drain test failed for pod %s: %v", config.PodName, err)
+		}
+	}
 
-func createTarGz(outputFile string, sourceDir string) error {
-	tarFile, err := os.Create(outputFile)
-	if err != nil {
-		return err
+	if logErr := waitLogs(); logErr != nil && config.FailFast {
+		return nil, failFast(logErr)
 	}
-	defer tarFile.Close()
 
-	gzipWriter := gzip.NewWriter(tarFile)
-	defer gzipWriter.Close()
+	if config.BundleJSON {
+		if err := writeEndpointBundle(tempDir, config, captured); err != nil {
+			log.Printf("Failed to write %s for pod %s: %v", endpointBundleFileName, config.PodName, err)
+		}
+	}
 
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+	result.KeyFindings = keyFindings(tempDir)
+	result.Health = healthSummary(tempDir)
+	manifest.Summary.Health = result.Health
 
-	err = filepath.Walk(sourceDir, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if config.DirectoryOnly {
+		if err := writeManifest(tempDir, manifest); err != nil {
+			return nil, fmt.Errorf("write manifest: %w", err)
 		}
-		if fi.IsDir() {
-			return nil
+		fmt.Printf("Snapshot for %s saved in %s\n", config.PodName, tempDir)
+		result.SnapshotDir = tempDir
+		result.CompletedAt = time.Now()
+
+		if config.OnComplete != "" {
+			if err := runOnCompleteHook(config.OnComplete, result); err != nil {
+				log.Printf("On-complete hook failed for pod %s: %v", config.PodName, err)
+			}
+		}
+	} else if config.StageDir != "" {
+		if err := writeManifest(tempDir, manifest); err != nil {
+			return nil, fmt.Errorf("write manifest: %w", err)
 		}
+		fmt.Printf("Snapshot for %s staged for %s\n", config.PodName, tarFilePath)
+		result.CompletedAt = time.Now()
+	} else if config.DryRunTar {
+		if err := writeManifest(tempDir, manifest); err != nil {
+			return nil, fmt.Errorf("write manifest: %w", err)
+		}
+		fmt.Printf("Dry run: %s would contain:\n", tarFilePath)
+		if err := listBundleFiles(os.Stdout, tempDir); err != nil {
+			return nil, err
+		}
+		result.CompletedAt = time.Now()
+	} else if config.AppendTar != "" {
+		if err := writeManifest(tempDir, manifest); err != nil {
+			return nil, fmt.Errorf("write manifest: %w", err)
+		}
+		entryDir := appendTarEntryDir(config)
+		if err := appendToTar(config.AppendTar, tempDir, entryDir); err != nil {
+			return nil, fmt.Errorf("failed to append to %s: %w", config.AppendTar, err)
+		}
+		fmt.Printf("Snapshot for %s appended to %s under %s/\n", config.PodName, config.AppendTar, entryDir)
 
-		relPath, err := filepath.Rel(sourceDir, file)
-		if err != nil {
-			return err
+		result.TarPath = config.AppendTar
+		result.CompletedAt = time.Now()
+
+		if config.OnComplete != "" {
+			if err := runOnCompleteHook(config.OnComplete, result); err != nil {
+				log.Printf("On-complete hook failed for pod %s: %v", config.PodName, err)
+			}
+		}
+	} else {
+		// Bundle snapshot
+		archiveOpts := archiveOptions{PerFileCompression: config.PerFileCompression, CompressConcurrency: config.CompressConcurrency}
+		if err := bundleWithinSize(tempDir, tarFilePath, config.MaxSnapshotSize, archiveOpts, manifest); err != nil {
+			return nil, fmt.Errorf("failed to create tar.gz file: %w", err)
 		}
+		fmt.Printf("Snapshot for %s saved as %s\n", config.PodName, tarFilePath)
 
-		header, err := tar.FileInfoHeader(fi, relPath)
-		if err != nil {
-			return err
+		result.TarPath = tarFilePath
+		result.CompletedAt = time.Now()
+
+		if config.OnComplete != "" {
+			if err := runOnCompleteHook(config.OnComplete, result); err != nil {
+				log.Printf("On-complete hook failed for pod %s: %v", config.PodName, err)
+			}
 		}
-		header.Name = relPath
+	}
 
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
+	// Reset log level via EPHEMERAL container
+	if (!config.SkipLogLevelReset || result.Interrupted) && !config.EphemeralDisabled {
+		log.Printf("Resetting Envoy log level back to 'info' on pod: %s", config.PodName)
+		if err := admin.SetLogLevel("info"); err != nil {
+			log.Printf("Failed to reset log level to info: %v", err)
 		}
+	}
 
-		f, err := os.Open(file)
+	return result, nil
+}
+
+// sidecarContainer is the Envoy container of the capture: the first extra log
+// container (the detected sidecar or gateway), else ContainerName.
+func sidecarContainer(config SnapshotConfig) string {
+	if len(config.ExtraLogs) > 0 && config.ExtraLogs[0] != "" {
+		return config.ExtraLogs[0]
+	}
+	return config.ContainerName
+}
+
+// withStatsUsedOnly adds the usedonly parameter to a /stats request, in text
+// or JSON format. Other endpoints, such as /stats/prometheus, are unchanged.
+func withStatsUsedOnly(endpoint string) string {
+	path, query, _ := strings.Cut(endpoint, "?")
+	if path != "/stats" {
+		return endpoint
+	}
+	for _, param := range strings.Split(query, "&") {
+		if param == "usedonly" || strings.HasPrefix(param, "usedonly=") {
+			return endpoint
+		}
+	}
+	if query == "" {
+		return path + "?usedonly"
+	}
+	return endpoint + "&usedonly"
+}
+
+// podArchiveName is the pod's tarball name, qualified with the namespace when
+// same-named pods from several namespaces share the snapshot directory.
+func podArchiveName(config SnapshotConfig) string {
+	if config.NamespacedArchive {
+		return fmt.Sprintf("%s_%s_snapshot.tar.gz", config.Namespace, config.PodName)
+	}
+	return fmt.Sprintf("%s_snapshot.tar.gz", config.PodName)
+}
+
+// withOutputPrefix prepends the --output-prefix (e.g. "INC-1234") to a file or
+// directory name.
+func withOutputPrefix(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// prometheusNameReplacer flattens a Prometheus endpoint path and query into a
+// single file name.
+var prometheusNameReplacer = strings.NewReplacer("/", "_", "?", "_", "&", "_", "=", "_")
+
+// endpointFileName returns the snapshot file name used to store an admin endpoint's output.
+func endpointFileName(endpoint string) string {
+	if name, ok := endpointFileNames[endpoint]; ok {
+		return name
+	}
+	if name, ok := configDumpResourceFileName(endpoint); ok {
+		return name
+	}
+	// Prometheus text lands at the top level, e.g. /stats/prometheus ->
+	// stats_prometheus.txt. The query is kept in the name, so
+	// /stats/prometheus?usedonly -> stats_prometheus_usedonly.txt does not
+	// overwrite the unfiltered output.
+	if path, _, _ := strings.Cut(endpoint, "?"); strings.HasSuffix(path, "/prometheus") {
+		return prometheusNameReplacer.Replace(strings.TrimPrefix(endpoint, "/")) + ".txt"
+	}
+	return fmt.Sprintf("%s.json", strings.TrimPrefix(endpoint, "/"))
+}
+
+// logStreamAttempts bounds the tries at opening a container's log stream; the
+// wait between tries starts at logStreamBackoff and doubles.
+const (
+	logStreamAttempts = 4
+	logStreamBackoff  = time.Second
+)
+
+// streamLogsWithTimeout follows a container's logs for duration, writing them
+// to w as they arrive, and returns the number of attempts it took to open the
+// stream. Opening is retried with backoff, within duration, so a briefly
+// unready container is still captured. w is no longer written to on return.
+func streamLogsWithTimeout(ctx context.Context, kubeService kube.KubernetesApiService, pod, container string, duration time.Duration, w io.Writer) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var attempts atomic.Int32
+	done := make(chan error, 1)
+	go func() {
+		backoff := logStreamBackoff
+		for {
+			n := attempts.Add(1)
+			err := kubeService.FetchContainerLogs(ctx, pod, container, true, w)
+			if !errors.Is(err, kube.ErrLogStreamOpen) || n >= logStreamAttempts {
+				done <- err
+				return
+			}
+			log.Printf("Could not open log stream for container %s (attempt %d/%d), retrying in %s: %v", container, n, logStreamAttempts, backoff, err)
+			select {
+			case <-ctx.Done():
+				done <- err
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// The stream is bound to ctx and ends with it.
+		<-done
+		return int(attempts.Load()), nil
+	case err := <-done:
+		return int(attempts.Load()), err
+	}
+}
+
+// streamLogsToFile runs streamLogsWithTimeout into path. With toDisk the logs
+// are written as they arrive, so memory stays bounded; otherwise they are
+// buffered and written once the stream ends. With a rolling window only the
+// logs received during the last window are kept. Nothing is kept on failure.
+func streamLogsToFile(ctx context.Context, kubeService kube.KubernetesApiService, pod, container string, duration, window time.Duration, path string, toDisk bool) (int, error) {
+	if window > 0 {
+		return streamRollingLogsToFile(ctx, kubeService, pod, container, duration, window, path, toDisk)
+	}
+	if !toDisk {
+		var buf bytes.Buffer
+		attempts, err := streamLogsWithTimeout(ctx, kubeService, pod, container, duration, &buf)
 		if err != nil {
-			return err
+			return attempts, err
 		}
-		defer f.Close()
+		return attempts, os.WriteFile(path, buf.Bytes(), 0o644)
+	}
 
-		_, err = io.Copy(tarWriter, f)
-		return err
-	})
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	attempts, err := streamLogsWithTimeout(ctx, kubeService, pod, container, duration, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return attempts, err
+}
+
+// streamRollingLogsToFile is streamLogsToFile with a rolling window: segments
+// older than window are dropped while the stream runs (on disk next to path
+// with toDisk, else in memory) and the rest is written to path at the end.
+func streamRollingLogsToFile(ctx context.Context, kubeService kube.KubernetesApiService, pod, container string, duration, window time.Duration, path string, toDisk bool) (int, error) {
+	dir := ""
+	if toDisk {
+		var err error
+		if dir, err = os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+".segments-*"); err != nil {
+			return 0, err
+		}
+		defer os.RemoveAll(dir)
+	}
+	rolling := newRollingWriter(window, dir)
+	attempts, err := streamLogsWithTimeout(ctx, kubeService, pod, container, duration, rolling)
+	if err != nil {
+		rolling.finish(io.Discard)
+		return attempts, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		rolling.finish(io.Discard)
+		return attempts, err
+	}
+	dropped, err := rolling.finish(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return attempts, err
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d bytes of %s logs older than the %s rolling window", dropped, container, window)
+	}
+	return attempts, nil
+}
+
+// writeToFile creates path and fills it with write, removing it again if the
+// write fails or produces nothing.
+func writeToFile(path string, write func(io.Writer) error) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{w: f}
+	err = write(counter)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && counter.n == 0 {
+		err = fmt.Errorf("no data received")
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	return err
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
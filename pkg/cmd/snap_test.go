@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+func TestEndpointFileName(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"/stats/prometheus", "stats_prometheus.txt"},
+		{"/stats/prometheus?usedonly", "stats_prometheus_usedonly.txt"},
+		{"/stats/prometheus?usedonly&filter=http", "stats_prometheus_usedonly_filter_http.txt"},
+		{"/clusters", "clusters.json"},
+		{RecentLookupsEndpoint, "recentlookups.txt"},
+		{XDSStatsEndpoint, "xds-stats.txt"},
+		{"/config_dump?resource=dynamic_listeners", "envoy/config/listeners.json"},
+		{"/config_dump?resource=dynamic_endpoint_configs&include_eds", "envoy/config/endpoints.json"},
+		{"/config_dump?resource=unknown", "config_dump?resource=unknown.json"},
+	}
+	for _, tt := range tests {
+		if got := endpointFileName(tt.endpoint); got != tt.want {
+			t.Errorf("endpointFileName(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+// failFastKube is a pod whose admin endpoints answer 404 and whose app
+// container logs stream until the capture stops them.
+type failFastKube struct {
+	fakeAdminKube
+
+	logsStopped  chan struct{}
+	cleanupCalls int
+}
+
+func (f *failFastKube) GetPodJSON(pod string) ([]byte, error) {
+	return nil, errors.New("not found")
+}
+
+func (f *failFastKube) GetNetworkPoliciesForPod(pod string) ([]byte, error) {
+	return nil, errors.New("forbidden")
+}
+
+func (f *failFastKube) FetchContainerLogs(ctx context.Context, pod, container string, follow bool, out io.Writer) error {
+	io.WriteString(out, "starting\n")
+	<-ctx.Done()
+	close(f.logsStopped)
+	return ctx.Err()
+}
+
+func (f *failFastKube) CleanupEphemeral() error {
+	f.cleanupCalls++
+	return nil
+}
+
+func TestCaptureSnapshotFailFast(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	notFound := &kube.HTTPStatusError{Path: "/config_dump", Status: "404 Not Found", StatusCode: http.StatusNotFound}
+	k := &failFastKube{
+		fakeAdminKube: fakeAdminKube{portForward: []fakeResponse{{err: notFound}}},
+		logsStopped:   make(chan struct{}),
+	}
+
+	_, err := CaptureSnapshot(context.Background(), k, SnapshotConfig{
+		PodName:           "web-7d9f",
+		ContainerName:     "app",
+		OutputDir:         t.TempDir(),
+		Endpoints:         []string{"/config_dump"},
+		CaptureOrder:      []string{PhaseLogs, PhaseConfig},
+		Duration:          time.Minute,
+		EphemeralDisabled: true,
+		FailFast:          true,
+	})
+	if err == nil {
+		t.Fatal("CaptureSnapshot succeeded, want the /config_dump failure")
+	}
+
+	select {
+	case <-k.logsStopped:
+	default:
+		t.Error("log stream still running after the capture returned")
+	}
+	if k.cleanupCalls != 1 {
+		t.Errorf("CleanupEphemeral calls = %d, want 1", k.cleanupCalls)
+	}
+	kept, _ := filepath.Glob(filepath.Join(tmp, "xdsnap-web-7d9f-*", "app-logs.txt"))
+	if len(kept) != 1 {
+		t.Errorf("partial capture not kept in %s: %v", tmp, kept)
+	}
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CaptureState records the progress of a capture session so an interrupted
+// run started with the same --state-file resumes instead of starting over.
+type CaptureState struct {
+	// Iterations is the number of scheduled rounds that finished.
+	Iterations int `json:"iterations"`
+	// StartedAt anchors --duration across restarts.
+	StartedAt time.Time `json:"started_at,omitempty"`
+	// SnapshotDir and CompletedPods describe the round in progress, if any.
+	SnapshotDir   string    `json:"snapshot_dir,omitempty"`
+	CompletedPods []string  `json:"completed_pods,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// loadCaptureState reads the state file, returning an empty state if it does
+// not exist yet.
+func loadCaptureState(path string) (*CaptureState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &CaptureState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state CaptureState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// podCompleted reports whether pod was already captured in the round writing
+// to snapshotDir.
+func (s *CaptureState) podCompleted(snapshotDir, pod string) bool {
+	return s.SnapshotDir == snapshotDir && containsString(s.CompletedPods, pod)
+}
+
+// save writes the state atomically so a crash mid-write cannot corrupt it.
+func (s *CaptureState) save(path string) error {
+	s.UpdatedAt = time.Now()
+	tmp := path + ".tmp"
+	if err := writeJSON(tmp, s); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
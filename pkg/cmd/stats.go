@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const statsRatesFileName = "stats-rates.txt"
+
+// parseStatsText parses Envoy's plain-text "name: value" /stats output, keeping
+// integer counters and gauges and skipping histogram summaries.
+func parseStatsText(content string) map[string]int64 {
+	stats := map[string]int64{}
+	for _, line := range strings.Split(content, "\n") {
+		name, raw, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[name] = value
+	}
+	return stats
+}
+
+// StatsBaseline is the /stats output of an earlier snapshot used by --baseline.
+type StatsBaseline struct {
+	PodName    string
+	CapturedAt time.Time
+	Stats      map[string]int64
+}
+
+// loadStatsBaseline reads stats.json and manifest.json from a previous snapshot
+// archive without extracting it.
+func loadStatsBaseline(bundlePath string) (*StatsBaseline, error) {
+	statsFile := endpointFileName("/stats")
+	files, err := readTarGzEntries(bundlePath, statsFile, manifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	stats, ok := files[statsFile]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s", bundlePath, statsFile)
+	}
+
+	baseline := &StatsBaseline{Stats: parseStatsText(string(stats.data)), CapturedAt: stats.modTime}
+	if raw, ok := files[manifestFileName]; ok {
+		var m Manifest
+		if err := json.Unmarshal(raw.data, &m); err == nil {
+			baseline.PodName = m.PodName
+			switch {
+			case !m.StatsCapturedAt.IsZero():
+				baseline.CapturedAt = m.StatsCapturedAt
+			case !m.CapturedAt.IsZero():
+				baseline.CapturedAt = m.CapturedAt
+			}
+		}
+	}
+	return baseline, nil
+}
+
+type tarEntry struct {
+	data    []byte
+	modTime time.Time
+}
+
+// readTarGzEntries returns the named entries of a snapshot archive. An entry
+// stored compressed, as <name>.gz, is decompressed and returned under name.
+func readTarGzEntries(bundlePath string, names ...string) (map[string]tarEntry, error) {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	out := map[string]tarEntry{}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.ToSlash(filepath.Clean(header.Name))
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		read := io.ReadAll
+		if plain, ok := strings.CutSuffix(name, ".gz"); ok && want[plain] {
+			name, read = plain, gunzipAll
+		}
+		if !want[name] {
+			continue
+		}
+		data, err := read(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", header.Name, err)
+		}
+		out[name] = tarEntry{data: data, modTime: header.ModTime}
+	}
+}
+
+// writeStatsRates compares fresh stats against a baseline and writes per-second
+// rates for every stat that changed, largest absolute rate first.
+func writeStatsRates(path string, baseline *StatsBaseline, current map[string]int64, capturedAt time.Time) error {
+	elapsed := capturedAt.Sub(baseline.CapturedAt).Seconds()
+	if elapsed <= 0 {
+		return fmt.Errorf("baseline (%s) is not older than this capture (%s)", baseline.CapturedAt.Format(time.RFC3339), capturedAt.Format(time.RFC3339))
+	}
+
+	type rate struct {
+		name  string
+		delta int64
+		perS  float64
+	}
+	var rates []rate
+	for name, value := range current {
+		prev, ok := baseline.Stats[name]
+		if !ok || value == prev {
+			continue
+		}
+		delta := value - prev
+		rates = append(rates, rate{name, delta, float64(delta) / elapsed})
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		ai, aj := rates[i].perS, rates[j].perS
+		if ai < 0 {
+			ai = -ai
+		}
+		if aj < 0 {
+			aj = -aj
+		}
+		if ai != aj {
+			return ai > aj
+		}
+		return rates[i].name < rates[j].name
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# baseline: %s\n", baseline.CapturedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "# current:  %s\n", capturedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "# interval: %.1fs, %d changed stats\n", elapsed, len(rates))
+	for _, r := range rates {
+		fmt.Fprintf(&b, "%s: delta=%d rate=%.3f/s\n", r.name, r.delta, r.perS)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import "testing"
+
+func TestLoadStatsBaselineReadsGzippedStats(t *testing.T) {
+	baseline, err := loadStatsBaseline(compressedSnapshot)
+	if err != nil {
+		t.Fatalf("loadStatsBaseline: %v", err)
+	}
+	if got := baseline.Stats["cluster.web.upstream_rq_total"]; got != 42 {
+		t.Errorf("cluster.web.upstream_rq_total = %d, want 42", got)
+	}
+	if baseline.PodName != "web-7d9f" {
+		t.Errorf("PodName = %q, want web-7d9f from manifest.json", baseline.PodName)
+	}
+}
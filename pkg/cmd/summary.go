@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Capture outcomes reported per pod in the round summary and index.json.
+const (
+	OutcomeCompleted = "completed"
+	// OutcomePodTimeout: the pod's capture took longer than --pod-timeout.
+	OutcomePodTimeout = "pod_timeout"
+	// OutcomeDeadline: the capture ran past the overall --duration deadline.
+	OutcomeDeadline = "deadline_exceeded"
+	// OutcomeSkippedDeadline: the deadline passed before the pod was started.
+	OutcomeSkippedDeadline = "skipped_deadline"
+	OutcomeFailed          = "failed"
+)
+
+// PodSummary is one pod's line in the summary printed after each round.
+type PodSummary struct {
+	Target    string
+	Outcome   string
+	Elapsed   time.Duration
+	Endpoints string
+	Detail    string
+}
+
+// captureOutcome classifies a finished capture against the per-pod timeout and
+// the overall deadline (either may be zero to disable it).
+func captureOutcome(elapsed, podTimeout time.Duration, deadline, finished time.Time) string {
+	switch {
+	case podTimeout > 0 && elapsed > podTimeout:
+		return OutcomePodTimeout
+	case !deadline.IsZero() && finished.After(deadline):
+		return OutcomeDeadline
+	default:
+		return OutcomeCompleted
+	}
+}
+
+// printCaptureSummary writes a table of per-pod outcomes and timings.
+func printCaptureSummary(w io.Writer, summaries []PodSummary) error {
+	if len(summaries) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD\tOUTCOME\tELAPSED\tENDPOINTS\tDETAIL")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Target, s.Outcome, s.Elapsed.Round(time.Second), valueOr(s.Endpoints, "-"), s.Detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/markcampv/xDSnap/kube"
+)
+
+// captureTcpdumpFiles runs a file-based tcpdump (optionally rotated) in the pod and
+// copies every resulting pcap into destDir over exec, avoiding the base64-through-logs path.
+func captureTcpdumpFiles(kubeService kube.KubernetesApiService, config SnapshotConfig, destDir string) ([]string, error) {
+	containers := []string{config.ContainerName, "envoy-sidecar", "consul-dataplane"}
+	target, err := kubeService.PickSidecarContainer(config.PodName, containers)
+	if err != nil {
+		return nil, err
+	}
+
+	ecName, err := kubeService.StartEphemeralTcpdumpToFiles(config.PodName, target, config.Duration, config.TcpdumpRotate, config.RollingWindow)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := kubeService.ReleaseEphemeralCapture(config.PodName, ecName); err != nil {
+			log.Printf("Failed to release tcpdump container %s: %v", ecName, err)
+		}
+	}()
+
+	var listing bytes.Buffer
+	if _, err := kubeService.ExecuteCommand(config.PodName, ecName, []string{"ls", "-1", kube.EphemeralCaptureDir}, &listing); err != nil {
+		return nil, fmt.Errorf("list pcap files: %w", err)
+	}
+
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", destDir, err)
+	}
+
+	var saved []string
+	for _, name := range strings.Split(listing.String(), "\n") {
+		name = strings.TrimSpace(name)
+		if !strings.HasSuffix(name, ".pcap") {
+			continue
+		}
+		localPath := filepath.Join(destDir, name)
+		f, err := os.Create(localPath)
+		if err != nil {
+			return saved, fmt.Errorf("create %s: %w", localPath, err)
+		}
+		err = kubeService.CopyFileFromPod(config.PodName, ecName, path.Join(kube.EphemeralCaptureDir, name), f)
+		f.Close()
+		if err != nil {
+			log.Printf("Failed to copy %s from %s: %v", name, ecName, err)
+			os.Remove(localPath)
+			continue
+		}
+		saved = append(saved, localPath)
+	}
+
+	if len(saved) == 0 {
+		return nil, fmt.Errorf("no pcap files produced by %s", ecName)
+	}
+	return saved, nil
+}
+
+// tcpdumpDecodeConcurrency caps how many base64 tcpdump log streams are decoded
+// at once. Each stream is decoded straight to disk, so memory stays bounded by
+// the copy buffers regardless of capture size.
+const tcpdumpDecodeConcurrency = 2
+
+// tcpdumpStream is a base64 tcpdump stream in an ephemeral container's logs,
+// decoded into File (relative to the snapshot directory).
+type tcpdumpStream struct {
+	Container string
+	File      string
+}
+
+// decodeTcpdumpStreams decodes every stream into its pcap, at most
+// tcpdumpDecodeConcurrency at a time, and reports one ManifestPcap per stream
+// in the order given.
+func decodeTcpdumpStreams(kubeService kube.KubernetesApiService, pod, destDir string, streams []tcpdumpStream) []ManifestPcap {
+	results := make([]ManifestPcap, len(streams))
+	sem := make(chan struct{}, tcpdumpDecodeConcurrency)
+	var wg sync.WaitGroup
+	for i, s := range streams {
+		wg.Add(1)
+		go func(i int, s tcpdumpStream) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = ManifestPcap{File: s.File, Container: s.Container}
+			n, err := decodeTcpdumpStream(kubeService, pod, s.Container, filepath.Join(destDir, s.File))
+			results[i].Bytes = n
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	return results
+}
+
+// decodeTcpdumpStream streams the container's logs through a base64 decoder
+// into path and returns the pcap size. Partial output is removed on failure.
+func decodeTcpdumpStream(kubeService kube.KubernetesApiService, pod, container, path string) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(kubeService.FetchContainerLogs(context.Background(), pod, container, false, pw))
+	}()
+	n, err := io.Copy(f, base64.NewDecoder(base64.StdEncoding, base64Filter{r: pr}))
+	pr.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n == 0 {
+		err = errors.New("no tcpdump data in logs")
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("decode tcpdump logs of %s: %w", container, err)
+	}
+	return n, nil
+}
+
+// base64Filter drops everything outside the base64 alphabet (newlines, log
+// line prefixes' separators) so the stream can be fed to base64.NewDecoder.
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		kept := 0
+		for _, c := range p[:n] {
+			if isBase64Char(c) {
+				p[kept] = c
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func isBase64Char(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '='
+}
+
+// pcapSizes reports the pcaps a file-based capture saved, relative to snapshotDir.
+func pcapSizes(snapshotDir string, paths []string) []ManifestPcap {
+	var pcaps []ManifestPcap
+	for _, p := range paths {
+		pcap := ManifestPcap{File: p}
+		if rel, err := filepath.Rel(snapshotDir, p); err == nil {
+			pcap.File = filepath.ToSlash(rel)
+		}
+		if info, err := os.Stat(p); err == nil {
+			pcap.Bytes = info.Size()
+		}
+		pcaps = append(pcaps, pcap)
+	}
+	return pcaps
+}
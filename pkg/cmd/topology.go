@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Topology summarizes listener -> route -> cluster chains joined from
+// /config_dump and /listeners.
+type Topology struct {
+	Listeners []TopologyListener `json:"listeners"`
+}
+
+type TopologyListener struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	// Clusters are targeted directly by the listener (e.g. tcp_proxy).
+	Clusters     []string        `json:"clusters,omitempty"`
+	RouteConfigs []TopologyRoute `json:"route_configs,omitempty"`
+	// MissingClusters are referenced by the chain but absent from the cluster set.
+	MissingClusters []string `json:"missing_clusters,omitempty"`
+}
+
+type TopologyRoute struct {
+	Name         string                `json:"name"`
+	Found        bool                  `json:"found"`
+	VirtualHosts []TopologyVirtualHost `json:"virtual_hosts,omitempty"`
+}
+
+type TopologyVirtualHost struct {
+	Name     string   `json:"name"`
+	Domains  []string `json:"domains,omitempty"`
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// writeTopology builds topology.json from the config_dump.json and listeners.json
+// already captured in snapshotDir.
+func writeTopology(snapshotDir string) error {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if err != nil {
+		return fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse config_dump: %w", err)
+	}
+
+	addresses := map[string]string{}
+	if raw, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/listeners"))); err == nil {
+		addresses = parseListenersText(string(raw))
+	}
+
+	topology := buildTopology(doc, addresses)
+	out, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(snapshotDir, "topology.json"), out, 0o644)
+}
+
+func buildTopology(doc any, addresses map[string]string) *Topology {
+	knownClusters := map[string]bool{}
+	for _, c := range configDumpClusters(doc) {
+		knownClusters[jsonString(c["name"])] = true
+	}
+
+	routes := map[string]map[string]any{}
+	for _, rc := range configDumpRouteConfigs(doc) {
+		routes[jsonString(rc["name"])] = rc
+	}
+
+	topology := &Topology{Listeners: []TopologyListener{}}
+	for _, l := range configDumpListeners(doc) {
+		tl := TopologyListener{
+			Name:    jsonString(l["name"]),
+			Address: socketAddress(jsonPath(l, "address")),
+		}
+		if tl.Address == "" {
+			tl.Address = addresses[tl.Name]
+		}
+
+		var referenced []string
+		for _, fc := range jsonSlice(l["filter_chains"]) {
+			for _, f := range jsonSlice(jsonPath(fc, "filters")) {
+				cfg := jsonMap(jsonPath(f, "typed_config"))
+				if cluster := jsonString(cfg["cluster"]); cluster != "" {
+					tl.Clusters = appendUnique(tl.Clusters, cluster)
+					referenced = append(referenced, cluster)
+				}
+				if name := jsonString(jsonPath(cfg, "rds", "route_config_name")); name != "" {
+					rc, found := routes[name]
+					tl.RouteConfigs = append(tl.RouteConfigs, buildTopologyRoute(name, rc, found))
+				}
+				if inline := jsonMap(cfg["route_config"]); inline != nil {
+					name := jsonString(inline["name"])
+					if name == "" {
+						name = "(inline)"
+					}
+					tl.RouteConfigs = append(tl.RouteConfigs, buildTopologyRoute(name, inline, true))
+				}
+			}
+		}
+		for _, rc := range tl.RouteConfigs {
+			for _, vh := range rc.VirtualHosts {
+				referenced = append(referenced, vh.Clusters...)
+			}
+		}
+		for _, c := range referenced {
+			if !knownClusters[c] {
+				tl.MissingClusters = appendUnique(tl.MissingClusters, c)
+			}
+		}
+		topology.Listeners = append(topology.Listeners, tl)
+	}
+
+	sort.Slice(topology.Listeners, func(i, j int) bool {
+		return topology.Listeners[i].Name < topology.Listeners[j].Name
+	})
+	return topology
+}
+
+func buildTopologyRoute(name string, rc map[string]any, found bool) TopologyRoute {
+	tr := TopologyRoute{Name: name, Found: found}
+	for _, vh := range jsonSlice(rc["virtual_hosts"]) {
+		tvh := TopologyVirtualHost{Name: jsonString(jsonPath(vh, "name"))}
+		for _, d := range jsonSlice(jsonPath(vh, "domains")) {
+			tvh.Domains = append(tvh.Domains, jsonString(d))
+		}
+		for _, r := range jsonSlice(jsonPath(vh, "routes")) {
+			action := jsonMap(jsonPath(r, "route"))
+			if c := jsonString(action["cluster"]); c != "" {
+				tvh.Clusters = appendUnique(tvh.Clusters, c)
+			}
+			for _, wc := range jsonSlice(jsonPath(action, "weighted_clusters", "clusters")) {
+				if c := jsonString(jsonPath(wc, "name")); c != "" {
+					tvh.Clusters = appendUnique(tvh.Clusters, c)
+				}
+			}
+		}
+		tr.VirtualHosts = append(tr.VirtualHosts, tvh)
+	}
+	return tr
+}
+
+func socketAddress(addr any) string {
+	sa := jsonMap(jsonPath(addr, "socket_address"))
+	if sa == nil {
+		return jsonString(jsonPath(addr, "pipe", "path"))
+	}
+	host := jsonString(sa["address"])
+	port := fmt.Sprint(sa["port_value"])
+	if sa["port_value"] == nil {
+		return host
+	}
+	return host + ":" + port
+}
+
+// parseListenersText parses the plain-text /listeners output ("name::address").
+func parseListenersText(content string) map[string]string {
+	out := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		name, addr, ok := strings.Cut(strings.TrimSpace(line), "::")
+		if ok && name != "" {
+			out[name] = addr
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, existing := range list {
+		if existing == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxTrimPasses bounds how many times the bundle is rebuilt while trimming.
+const maxTrimPasses = 8
+
+// TrimmedFile records a file reduced or dropped to honour --max-snapshot-size.
+type TrimmedFile struct {
+	File         string `json:"file"`
+	Action       string `json:"action"`
+	OriginalSize int64  `json:"original_size"`
+	FinalSize    int64  `json:"final_size"`
+}
+
+// bundleWithinSize writes the manifest and bundles dir into tarPath. When maxSize
+// is positive and the archive exceeds it, the largest contributors are trimmed
+// (logs keep their newest half, pcaps their first half of packets, other dumps
+// are dropped) and the archive is rebuilt, recording every change in the manifest.
+func bundleWithinSize(dir, tarPath string, maxSize int64, opts archiveOptions, manifest *Manifest) error {
+	for pass := 0; ; pass++ {
+		if err := writeManifest(dir, manifest); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+		if err := createTarGz(tarPath, dir, opts); err != nil {
+			return err
+		}
+		if maxSize <= 0 {
+			return nil
+		}
+		fi, err := os.Stat(tarPath)
+		if err != nil {
+			return err
+		}
+		if fi.Size() <= maxSize {
+			return nil
+		}
+		if pass >= maxTrimPasses {
+			return fmt.Errorf("snapshot is %d bytes after trimming, above the %d byte limit", fi.Size(), maxSize)
+		}
+
+		trimmed, err := trimLargestFile(dir)
+		if err != nil {
+			return err
+		}
+		if trimmed == nil {
+			return fmt.Errorf("snapshot is %d bytes and nothing is left to trim below the %d byte limit", fi.Size(), maxSize)
+		}
+		log.Printf("Snapshot exceeds %d bytes; %s %s (%d -> %d bytes)", maxSize, trimmed.Action, trimmed.File, trimmed.OriginalSize, trimmed.FinalSize)
+		manifest.Trimmed = mergeTrimmed(manifest.Trimmed, *trimmed)
+	}
+}
+
+// untrimmable files are small and needed to interpret the snapshot.
+var untrimmable = map[string]bool{
+	manifestFileName: true,
+	"pod.json":       true,
+}
+
+func trimLargestFile(dir string) (*TrimmedFile, error) {
+	type candidate struct {
+		rel  string
+		size int64
+	}
+	var files []candidate
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !untrimmable[rel] && fi.Size() > 0 {
+			files = append(files, candidate{rel, fi.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].size > files[j].size })
+
+	largest := files[0]
+	path := filepath.Join(dir, filepath.FromSlash(largest.rel))
+	t := &TrimmedFile{File: largest.rel, OriginalSize: largest.size}
+
+	switch {
+	case strings.HasSuffix(largest.rel, ".pcap"):
+		t.Action = "downsampled"
+		err = truncatePcap(path, largest.size/2)
+	case strings.HasSuffix(largest.rel, ".txt") && largest.size > 4096:
+		t.Action = "truncated"
+		err = keepTail(path, largest.size/2)
+	default:
+		t.Action = "dropped"
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("trim %s: %w", largest.rel, err)
+	}
+	if fi, err := os.Stat(path); err == nil {
+		t.FinalSize = fi.Size()
+	}
+	return t, nil
+}
+
+// keepTail keeps the last n bytes of a file, starting at the next full line.
+func keepTail(path string, n int64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	tail := data[int64(len(data))-n:]
+	if i := strings.IndexByte(string(tail), '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	header := []byte("[xdsnap: log truncated to fit --max-snapshot-size]\n")
+	return os.WriteFile(path, append(header, tail...), 0o644)
+}
+
+// truncatePcap keeps whole packet records from the start of a classic pcap file
+// until limit bytes. Files that are not classic pcap are dropped instead.
+func truncatePcap(path string, limit int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return os.Remove(path)
+	}
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(header[:4]) {
+	case 0xa1b2c3d4, 0xa1b23c4d:
+		order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		order = binary.BigEndian
+	default:
+		return os.Remove(path)
+	}
+
+	keep := int64(len(header))
+	record := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(f, record); err != nil {
+			break
+		}
+		size := int64(order.Uint32(record[8:12]))
+		if keep+16+size > limit {
+			break
+		}
+		if _, err := f.Seek(size, io.SeekCurrent); err != nil {
+			break
+		}
+		keep += 16 + size
+	}
+	f.Close()
+	return os.Truncate(path, keep)
+}
+
+// mergeTrimmed folds repeated trims of the same file into one entry that keeps
+// the original size.
+func mergeTrimmed(list []TrimmedFile, t TrimmedFile) []TrimmedFile {
+	for i := range list {
+		if list[i].File == t.File {
+			list[i].FinalSize = t.FinalSize
+			list[i].Action = t.Action
+			return list
+		}
+	}
+	return append(list, t)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// endpointValidators hold lightweight shape checks for admin endpoints whose
+// responses can be well-formed yet useless (e.g. an empty dump during startup).
+// A failed check is treated like a failed fetch and retried.
+var endpointValidators = map[string]func([]byte) error{
+	"/config_dump":      validateConfigDump,
+	"/certs":            validateJSON,
+	"/clusters":         validateClustersText,
+	"/listeners":        validateNonBlank,
+	"/stats":            validateNonBlank,
+	"/init_dump":        validateJSON,
+	"/server_info":      validateNonBlank,
+	"/stats/prometheus": validateNonBlank,
+}
+
+// validateEndpointShape runs the validator registered for endpoint, if any.
+func validateEndpointShape(endpoint string, data []byte) error {
+	validate, ok := endpointValidators[endpoint]
+	if !ok {
+		return nil
+	}
+	if err := validate(data); err != nil {
+		return fmt.Errorf("unexpected %s response: %w", endpoint, err)
+	}
+	return nil
+}
+
+func validateNonBlank(data []byte) error {
+	if strings.TrimSpace(string(data)) == "" {
+		return errors.New("empty body")
+	}
+	return nil
+}
+
+func validateJSON(data []byte) error {
+	if !json.Valid(data) {
+		return errors.New("body is not valid JSON")
+	}
+	return nil
+}
+
+func validateClustersText(data []byte) error {
+	if !strings.Contains(string(data), "::") {
+		return errors.New("no cluster entries")
+	}
+	return nil
+}
+
+// validateConfigDump requires at least one listener or cluster in the dump.
+func validateConfigDump(data []byte) error {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("body is not valid JSON: %w", err)
+	}
+	if len(configDumpListeners(doc)) == 0 && len(configDumpClusters(doc)) == 0 {
+		return errors.New("no listeners or clusters in config_dump")
+	}
+	return nil
+}
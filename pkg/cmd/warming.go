@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WarmingSummary lists the clusters and listeners that were still warming when
+// /config_dump was captured. A resource stuck warming (e.g. waiting on EDS or
+// RDS) silently blackholes the traffic that depends on it.
+type WarmingSummary struct {
+	Clusters []string `json:"clusters,omitempty"`
+	// Listeners have a warming_state; NotActive is the subset with no active
+	// state at all, which are not serving yet.
+	Listeners []string `json:"listeners,omitempty"`
+	NotActive []string `json:"not_active_listeners,omitempty"`
+}
+
+// warmingState reads the captured config_dump. It returns nil, nil when no
+// config_dump was captured or nothing is warming.
+func warmingState(snapshotDir string) (*WarmingSummary, error) {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, endpointFileName("/config_dump")))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config_dump: %w", err)
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config_dump: %w", err)
+	}
+
+	w := configDumpWarming(doc)
+	if len(w.Clusters) == 0 && len(w.Listeners) == 0 {
+		return nil, nil
+	}
+	return w, nil
+}
+
+func configDumpWarming(doc any) *WarmingSummary {
+	w := &WarmingSummary{}
+	clusters := configDumpSection(doc, "ClustersConfigDump")
+	for _, c := range jsonSlice(clusters["dynamic_warming_clusters"]) {
+		if name := jsonString(jsonPath(c, "cluster", "name")); name != "" {
+			w.Clusters = append(w.Clusters, name)
+		}
+	}
+	listeners := configDumpSection(doc, "ListenersConfigDump")
+	for _, l := range jsonSlice(listeners["dynamic_listeners"]) {
+		if jsonPath(l, "warming_state") == nil {
+			continue
+		}
+		name := jsonString(jsonPath(l, "name"))
+		w.Listeners = append(w.Listeners, name)
+		if jsonPath(l, "active_state") == nil {
+			w.NotActive = append(w.NotActive, name)
+		}
+	}
+	sort.Strings(w.Clusters)
+	sort.Strings(w.Listeners)
+	sort.Strings(w.NotActive)
+	return w
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podRestart is a container restart seen by --watch.
+type podRestart struct {
+	Target       captureTarget
+	Container    string
+	RestartCount int32
+}
+
+// watchRetryDelay is the pause before re-listing after a failed list or watch.
+const watchRetryDelay = 5 * time.Second
+
+// watchPodRestarts sends a podRestart whenever a container of one of the
+// targets' pods increments its restartCount, until ctx is cancelled, and then
+// closes out. The watch is re-established (with a fresh list, so restarts in
+// between are not missed) whenever the API server closes it.
+func watchPodRestarts(ctx context.Context, clientset kubernetes.Interface, targets []captureTarget, out chan<- podRestart) {
+	byNamespace := map[string]map[string]captureTarget{}
+	for _, t := range targets {
+		if byNamespace[t.Namespace] == nil {
+			byNamespace[t.Namespace] = map[string]captureTarget{}
+		}
+		byNamespace[t.Namespace][t.Pod] = t
+	}
+	var wg sync.WaitGroup
+	for ns, pods := range byNamespace {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchNamespaceRestarts(ctx, clientset, ns, pods, out)
+		}()
+	}
+	wg.Wait()
+	close(out)
+}
+
+// watchNamespaceRestarts follows the target pods of one namespace. A single
+// target is selected by name, so busy namespaces are not listed in full.
+func watchNamespaceRestarts(ctx context.Context, clientset kubernetes.Interface, namespace string, pods map[string]captureTarget, out chan<- podRestart) {
+	var selector string
+	if len(pods) == 1 {
+		for name := range pods {
+			selector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}
+	}
+
+	// restarts holds the last seen restartCount per "pod/container".
+	restarts := map[string]int32{}
+	check := func(pod *corev1.Pod) {
+		target, ok := pods[pod.Name]
+		if !ok {
+			return
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			key := pod.Name + "/" + cs.Name
+			if prev, seen := restarts[key]; seen && cs.RestartCount > prev {
+				select {
+				case out <- podRestart{Target: target, Container: cs.Name, RestartCount: cs.RestartCount}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			restarts[key] = cs.RestartCount
+		}
+	}
+	retry := func() bool {
+		select {
+		case <-time.After(watchRetryDelay):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for ctx.Err() == nil {
+		list, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to list pods in namespace %s: %v", namespace, err)
+			}
+			if !retry() {
+				return
+			}
+			continue
+		}
+		for i := range list.Items {
+			check(&list.Items[i])
+		}
+
+		w, err := clientset.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{FieldSelector: selector, ResourceVersion: list.ResourceVersion})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to watch pods in namespace %s: %v", namespace, err)
+			}
+			if !retry() {
+				return
+			}
+			continue
+		}
+	events:
+		for {
+			select {
+			case <-ctx.Done():
+				w.Stop()
+				return
+			case ev, ok := <-w.ResultChan():
+				if !ok {
+					break events
+				}
+				if pod, ok := ev.Object.(*corev1.Pod); ok {
+					check(pod)
+				}
+			}
+		}
+		log.Printf("Pod watch in namespace %s closed, re-establishing", namespace)
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func restartingPod(namespace, name string, restarts int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "app", RestartCount: restarts},
+		}},
+	}
+}
+
+func TestWatchPodRestarts(t *testing.T) {
+	clientset := fake.NewSimpleClientset(restartingPod("ns1", "web-1", 0), restartingPod("ns1", "other", 0))
+	// watching is closed once the watch is established, so that updates made
+	// afterwards are seen.
+	watching := make(chan struct{})
+	var once sync.Once
+	var fieldSelector string
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		once.Do(func() {
+			fieldSelector = action.(k8stesting.WatchAction).GetWatchRestrictions().Fields.String()
+			close(watching)
+		})
+		return true, w, err
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	target := captureTarget{Namespace: "ns1", Pod: "web-1"}
+	out := make(chan podRestart)
+	go watchPodRestarts(ctx, clientset, []captureTarget{target}, out)
+
+	select {
+	case <-watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not established")
+	}
+	if fieldSelector != "metadata.name=web-1" {
+		t.Errorf("watch field selector = %q, want metadata.name=web-1", fieldSelector)
+	}
+
+	pods := clientset.CoreV1().Pods("ns1")
+	// A restart of a pod that is not a target is ignored.
+	if _, err := pods.UpdateStatus(ctx, restartingPod("ns1", "other", 1), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.UpdateStatus(ctx, restartingPod("ns1", "web-1", 1), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-out:
+		want := podRestart{Target: target, Container: "app", RestartCount: 1}
+		if r != want {
+			t.Errorf("restart = %+v, want %+v", r, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no restart reported")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Error("restart reported after cancellation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not stop on cancellation")
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"net/url"
+	"strings"
+)
+
+// XDSStatsEndpoint captures only the stats relevant to control-plane connectivity.
+var XDSStatsEndpoint = "/stats?filter=" + url.QueryEscape("(xds|control_plane|update)")
+
+// XDSStatsSummary condenses the xDS stats that answer "is config applying?".
+type XDSStatsSummary struct {
+	// ConnectedState is control_plane.connected_state (1 = connected to the xDS server).
+	ConnectedState *int `json:"connected_state,omitempty"`
+	UpdateSuccess  int  `json:"update_success"`
+	UpdateRejected int  `json:"update_rejected"`
+	UpdateFailure  int  `json:"update_failure"`
+	// Rejections lists every non-zero *.update_rejected counter by stat name.
+	Rejections map[string]int `json:"rejections,omitempty"`
+}
+
+// parseXDSStats summarizes Envoy's plain-text "name: value" stats output.
+func parseXDSStats(content string) *XDSStatsSummary {
+	summary := &XDSStatsSummary{Rejections: map[string]int{}}
+	for name, v := range parseStatsText(content) {
+		value := int(v)
+		switch {
+		case name == "control_plane.connected_state":
+			summary.ConnectedState = &value
+		case strings.HasSuffix(name, ".update_success"):
+			summary.UpdateSuccess += value
+		case strings.HasSuffix(name, ".update_rejected"):
+			summary.UpdateRejected += value
+			if value > 0 {
+				summary.Rejections[name] = value
+			}
+		case strings.HasSuffix(name, ".update_failure"):
+			summary.UpdateFailure += value
+		}
+	}
+	return summary
+}